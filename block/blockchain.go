@@ -3,110 +3,338 @@ package block
 import (
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go_blockchain/utils"
 	"log"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	MINING_DIFFICULTY = 3                // nouceを求める際に、先頭3つが000の値を探す
-	MINING_SENDER     = "THE BLOCKCHAIN" // マイニングする人(報酬を受け取る人)から見た、送信者（node側）のブロックチェーンアドレス
-	MINING_REWARD     = 1.0              // マイニングに成功した場合の報酬
+	MINING_DIFFICULTY = 12                  // difficultyの初期値。nouceを求める際に、ハッシュの先頭12ビット(16進数で3桁)が0の値を探す
+	MINING_SENDER     = "THE BLOCKCHAIN"    // マイニングする人(報酬を受け取る人)から見た、送信者（node側）のブロックチェーンアドレス
+	MINING_REWARD     = utils.AMOUNT_UNIT   // マイニングに成功した場合の報酬（1コイン）
+	GENESIS_TIMESTAMP = 1649490447286610000 // 1個目のブロックのタイムスタンプ。すべてのノードで同じブロックになるように固定する
+)
+
+// AddTransactionで受け付けられなかった理由
+var (
+	ErrInvalidSignature    = errors.New("invalid transaction signature")
+	ErrInsufficientBalance = errors.New("not enough balance in a wallet")
+	ErrNonceUsed           = errors.New("nonce already used (replayed transaction)")
+	ErrNonceTooHigh        = errors.New("nonce is ahead of the next expected nonce")
+	ErrMiningSender        = errors.New("mining reward cannot be sent as a transaction")
+	ErrInvalidValue        = errors.New("value must be positive")
+	ErrSenderAddress       = errors.New("sender address does not match the sender public key")
+)
+
+var (
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrKnownTransaction    = errors.New("transaction is already in the transaction pool")
+	ErrChainChanged        = errors.New("chain changed while mining")
 )
 
 // Block
 type Block struct {
 	nonce        int
+	difficulty   int // ハッシュの先頭に必要な0のビット数
 	previousHash [sha256.Size]byte
+	merkleRoot   [sha256.Size]byte // transactionsのMerkle treeの根
 	timestamp    int64
 	transactions []*Transaction
 }
 
-func NewBlock(nouce int, previousHash [sha256.Size]byte, transactions []*Transaction) *Block {
+func NewBlock(nouce int, previousHash [sha256.Size]byte, transactions []*Transaction, difficulty int) *Block {
 	b := new(Block) // newでpoint型を明示できる
 	b.timestamp = time.Now().UnixNano()
 	b.nonce = nouce
+	b.difficulty = difficulty
 	b.previousHash = previousHash
 	b.transactions = transactions
+	b.merkleRoot = MerkleRoot(transactions)
 	return b
 }
 
+// GenesisBlock
+// 1個目のブロック。トランザクションを持たず、すべてのノードで同じハッシュになる
+func GenesisBlock() *Block {
+	return &Block{
+		timestamp:    GENESIS_TIMESTAMP,
+		previousHash: (&Block{}).Hash(),
+		merkleRoot:   MerkleRoot(nil),
+	}
+}
+
+func (b *Block) Transactions() []*Transaction {
+	return b.transactions
+}
+
+func (b *Block) MerkleRoot() [sha256.Size]byte {
+	return b.merkleRoot
+}
+
 func (b *Block) Print() {
 	fmt.Printf("timestamp        %d\n", b.timestamp)
 	fmt.Printf("nonce            %d\n", b.nonce)
+	fmt.Printf("difficulty       %d\n", b.difficulty)
 	fmt.Printf("previous_hash    %x\n", b.previousHash)
+	fmt.Printf("merkle_root      %x\n", b.merkleRoot)
 	for _, t := range b.transactions {
 		t.Print()
 	}
 }
 
+// Hash
+// ヘッダーだけをハッシュ化する。トランザクションはmerkleRootを通して含まれる
 func (b *Block) Hash() [sha256.Size]byte {
-	m, _ := json.Marshal(b)
+	m, _ := json.Marshal(struct {
+		Timestamp    int64  `json:"timestamp"`
+		Nonce        int    `json:"nonce"`
+		Difficulty   int    `json:"difficulty"`
+		PreviousHash string `json:"previous_hash"`
+		MerkleRoot   string `json:"merkle_root"`
+	}{
+		Timestamp:    b.timestamp,
+		Nonce:        b.nonce,
+		Difficulty:   b.difficulty,
+		PreviousHash: fmt.Sprintf("%x", b.previousHash),
+		MerkleRoot:   fmt.Sprintf("%x", b.merkleRoot),
+	})
 	return sha256.Sum256([]byte(m))
 }
 
 // MarshalJSON
 // Blockのfieldがprivateなので、json化時にpublicにすることが必要
 func (b *Block) MarshalJSON() ([]byte, error) {
+	return b.marshalJSON(nil)
+}
+
+// marshalJSON heightがnilの場合はheightを含めない
+// hashは確認用に含めるだけで、UnmarshalJSONでは読み込まない
+func (b *Block) marshalJSON(height *int) ([]byte, error) {
 	return json.Marshal(struct {
+		Height       *int           `json:"height,omitempty"`
+		Hash         string         `json:"hash"`
 		Timestamp    int64          `json:"timestamp"`
 		Nonce        int            `json:"nonce"`
+		Difficulty   int            `json:"difficulty"`
 		PreviousHash string         `json:"previous_hash"`
+		MerkleRoot   string         `json:"merkle_root"`
 		Transactions []*Transaction `json:"transactions"`
 	}{
+		Height:       height,
+		Hash:         fmt.Sprintf("%x", b.Hash()),
 		Timestamp:    b.timestamp,
 		Nonce:        b.nonce,
+		Difficulty:   b.difficulty,
 		PreviousHash: fmt.Sprintf("%x", b.previousHash),
+		MerkleRoot:   fmt.Sprintf("%x", b.merkleRoot),
 		Transactions: b.transactions,
 	})
 }
 
+// ChainBlock chainでの高さ付きのブロック
+type ChainBlock struct {
+	Height int
+	Block  *Block
+}
+
+func (cb ChainBlock) MarshalJSON() ([]byte, error) {
+	return cb.Block.marshalJSON(&cb.Height)
+}
+
+// UnmarshalJSON MarshalJSONの形式から高さとブロックを復元する（APIのクライアント用）
+func (cb *ChainBlock) UnmarshalJSON(data []byte) error {
+	v := struct {
+		Height *int `json:"height"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Height == nil {
+		return fmt.Errorf("block: missing height")
+	}
+	b := new(Block)
+	if err := b.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	cb.Height = *v.Height
+	cb.Block = b
+	return nil
+}
+
+// UnmarshalJSON
+// Storageから読み込む時に、MarshalJSONの形式からBlockを復元する
+func (b *Block) UnmarshalJSON(data []byte) error {
+	v := struct {
+		Timestamp    *int64         `json:"timestamp"`
+		Nonce        *int           `json:"nonce"`
+		Difficulty   int            `json:"difficulty"`
+		PreviousHash *string        `json:"previous_hash"`
+		MerkleRoot   *string        `json:"merkle_root"`
+		Transactions []*Transaction `json:"transactions"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Timestamp == nil || v.Nonce == nil || v.PreviousHash == nil {
+		return fmt.Errorf("block: missing field(s)")
+	}
+	// nullのトランザクションはMerkleRootやValidChainで扱えないので、ここで弾く
+	for i, t := range v.Transactions {
+		if t == nil {
+			return fmt.Errorf("block: transaction %d is null", i)
+		}
+	}
+	ph, err := hex.DecodeString(*v.PreviousHash)
+	if err != nil {
+		return err
+	}
+	if len(ph) != sha256.Size {
+		return fmt.Errorf("block: invalid previous_hash length %d", len(ph))
+	}
+	b.timestamp = *v.Timestamp
+	b.nonce = *v.Nonce
+	b.difficulty = v.Difficulty
+	copy(b.previousHash[:], ph)
+	b.transactions = v.Transactions
+	// merkle_rootがない場合はtransactionsから計算する（正しいかはValidChainで検証する）
+	b.merkleRoot = MerkleRoot(b.transactions)
+	if v.MerkleRoot != nil {
+		mr, err := hex.DecodeString(*v.MerkleRoot)
+		if err != nil {
+			return err
+		}
+		if len(mr) != sha256.Size {
+			return fmt.Errorf("block: invalid merkle_root length %d", len(mr))
+		}
+		copy(b.merkleRoot[:], mr)
+	}
+	return nil
+}
+
 // Blockchain
 type Blockchain struct {
 	transactionPool   []*Transaction
 	chain             []*Block
 	blockchainAddress string
 	port              uint16
+	storage           Storage      // nilの場合はメモリ上だけで保持する
+	ledger            *ledger      // chainの最後までの残高とnonce
+	mux               sync.RWMutex // transactionPoolとchainの排他制御
+	muxMining         sync.Mutex   // マイニングは同時に1つだけ
+
+	neighbors       []string // 同期する隣のノード("host:port")
+	staticNeighbors []string
+	muxNeighbors    sync.Mutex
+	resolving       int32     // バックグラウンドでResolveConflictsしている間は1
+	lastResolved    time.Time // 最後にバックグラウンドでResolveConflictsを始めた時刻
 }
 
-func NewBlockchain(blockchainAddress string, port uint16) *Blockchain {
-	b := &Block{}
+// NewBlockchain
+// storageに保存済みのブロックがあればそれを読み込み、なければ1個目のブロック（GenesisBlock）から始める
+func NewBlockchain(blockchainAddress string, port uint16, storage Storage) (*Blockchain, error) {
 	bc := new(Blockchain)
 	bc.blockchainAddress = blockchainAddress
-	bc.CreateBlock(0, b.Hash()) // 1個目のブロック
 	bc.port = port
-	return bc
+	bc.storage = storage
+	bc.ledger = newLedger()
+	if storage != nil {
+		blocks, err := storage.Load()
+		if err != nil {
+			return nil, err
+		}
+		bc.chain = blocks
+	}
+	if len(bc.chain) == 0 {
+		if err := bc.appendBlock(GenesisBlock()); err != nil {
+			return nil, err
+		}
+	}
+	// 保存済みのブロックも、隣のノードから受け取ったchainと同じように検証する（1個目がGenesisBlockであることも含む）
+	if err := bc.validChain(bc.chain); err != nil {
+		return nil, fmt.Errorf("block: stored chain is invalid: %w", err)
+	}
+	bc.ledger = ledgerOf(bc.chain)
+	return bc, nil
 }
 
+// 公開しているメソッドはmuxでロックする
+// 小文字のメソッドは、呼び出し側がロックしている前提
+
+// TransactionPool マイニングと同時に呼ばれても良いように、コピーを返す
 func (bc *Blockchain) TransactionPool() []*Transaction {
-	return bc.transactionPool
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return append([]*Transaction{}, bc.transactionPool...)
+}
+
+// Chain ブロックは作成後に変更しないので、スライスだけコピーして返す
+func (bc *Blockchain) Chain() []*Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return append([]*Block{}, bc.chain...)
 }
 
 func (bc *Blockchain) MarshalJSON() ([]byte, error) {
+	chain := bc.Chain()
+	blocks := make([]ChainBlock, len(chain))
+	for i, b := range chain {
+		blocks[i] = ChainBlock{Height: i, Block: b}
+	}
 	return json.Marshal(struct {
-		Blocks []*Block `json:"chains"`
+		Blocks []ChainBlock `json:"chains"`
 	}{
-		Blocks: bc.chain,
+		Blocks: blocks,
 	})
 }
 
-func (bc *Blockchain) CreateBlock(nonce int, previousHash [sha256.Size]byte) *Block {
+// CreateBlock
+// storageへの書き込みに失敗した場合は、chainもtransactionPoolも変更しない
+func (bc *Blockchain) CreateBlock(nonce int, previousHash [sha256.Size]byte) (*Block, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.createBlock(nonce, previousHash, bc.expectedDifficulty(bc.chain, len(bc.chain)))
+}
+
+func (bc *Blockchain) createBlock(nonce int, previousHash [sha256.Size]byte, difficulty int) (*Block, error) {
 	// BlockchainのtransactionPoolから、Blockのtransactionsに渡す
-	b := NewBlock(nonce, previousHash, bc.transactionPool)
-	bc.chain = append(bc.chain, b)
+	b := NewBlock(nonce, previousHash, bc.transactionPool, difficulty)
+	if err := bc.appendBlock(b); err != nil {
+		return nil, err
+	}
 	bc.transactionPool = []*Transaction{} // 渡した後のtransactionPoolは空にする
-	return b
+	return b, nil
+}
+
+// appendBlock storageに書き込めた場合だけchainに繋げ、ledgerにも反映する
+func (bc *Blockchain) appendBlock(b *Block) error {
+	if bc.storage != nil {
+		if err := bc.storage.Append(b); err != nil {
+			return err
+		}
+	}
+	bc.chain = append(bc.chain, b)
+	bc.ledger.apply(b)
+	return nil
 }
 
 func (bc *Blockchain) LastBlock() *Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.lastBlock()
+}
+
+func (bc *Blockchain) lastBlock() *Block {
 	return bc.chain[len(bc.chain)-1]
 }
 
 func (bc *Blockchain) Print() {
-	for i, block := range bc.chain {
+	for i, block := range bc.Chain() {
 		borderString := strings.Repeat("=", 25)
 		fmt.Printf("%s Chain %d %s \n", borderString, i, borderString)
 		block.Print()
@@ -114,150 +342,450 @@ func (bc *Blockchain) Print() {
 	fmt.Printf("%s\n\n", strings.Repeat("*", 25))
 }
 
-func (bc *Blockchain) CreateTransaction(sender string, recipient string, value float32,
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	isTransacted := bc.AddTransaction(sender, recipient, value, senderPublicKey, s)
+// CreateTransaction
+// transactionPoolに入ったトランザクションを返す（IDで取り込まれたかを確認できる）
+// 新しいトランザクションの場合だけ隣のノードに送るので、ノードの間を回り続けることはない
+func (bc *Blockchain) CreateTransaction(sender string, recipient string, value utils.Amount, nonce uint64,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) (*Transaction, error) {
+	t, err := bc.AddTransaction(sender, recipient, value, nonce, senderPublicKey, s)
 
-	// TODO
-	// Sync
+	// 隣のノードのtransactionPoolにも同じトランザクションを入れる
+	if err == nil {
+		bc.broadcastTransaction(t, senderPublicKey, s)
+	}
 
-	return isTransacted
+	return t, err
 }
 
-func (bc *Blockchain) AddTransaction(sender string, recipient string, value float32,
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	t := NewTransaction(sender, recipient, value)
+// AddTransaction
+// すでにtransactionPoolにある場合は、そのトランザクションとErrKnownTransactionを返す
+func (bc *Blockchain) AddTransaction(sender string, recipient string, value utils.Amount, nonce uint64,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) (*Transaction, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.addTransaction(sender, recipient, value, nonce, senderPublicKey, s)
+}
 
-	// マイニングの時は、トランザクションの署名の検証不要
+func (bc *Blockchain) addTransaction(sender string, recipient string, value utils.Amount, nonce uint64,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) (*Transaction, error) {
+	t := NewTransaction(sender, recipient, value, nonce)
+
+	// マイニングの報酬はMiningの中でだけ作る
 	if sender == MINING_SENDER {
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
-	}
-
-	if bc.VerifyTransactionSignature(senderPublicKey, s, t) {
-		/*
-			if bc.CalculateTotalAmount(sender) < value {
-				log.Println("ERROR: Not enough balance in a wallet")
-				return false
-			}
-		*/
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
-	} else {
+		return nil, ErrMiningSender
+	}
+	if value <= 0 {
+		return nil, ErrInvalidValue
+	}
+	for _, address := range []string{sender, recipient} {
+		if err := utils.ValidateAddress(address); err != nil {
+			return nil, err
+		}
+	}
+
+	if !bc.VerifyTransactionSignature(senderPublicKey, s, t) {
 		log.Println("ERROR: Verify Transaction")
+		return nil, ErrInvalidSignature
+	}
+	// 他人の鍵で署名して、送信者だけを自分のアドレスにすり替えることを防ぐ
+	if utils.AddressFromPublicKey(senderPublicKey) != sender {
+		return nil, ErrSenderAddress
+	}
+	// 他のノードが検証できるように、署名と公開鍵もブロックに残す
+	t.senderPublicKey = senderPublicKey
+	t.signature = s
+
+	// 隣のノードから同じトランザクションが戻ってくることがある
+	for _, p := range bc.transactionPool {
+		if p.equal(t) {
+			return p, ErrKnownTransaction
+		}
+	}
+	if err := bc.checkPending(t, bc.transactionPool); err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+	bc.transactionPool = append(bc.transactionPool, t)
+	return t, nil
+}
+
+// checkPending
+// chainとpendingのトランザクションを踏まえて、tのnonceと残高が正しいか
+func (bc *Blockchain) checkPending(t *Transaction, pending []*Transaction) error {
+	sender := t.senderBlockchainAddress
+	var count uint64
+	var spent utils.Amount
+	for _, p := range pending {
+		if p.senderBlockchainAddress == sender {
+			count++
+			spent += p.value // まだブロックに入っていない送金も使用済みとみなす
+		}
+	}
+	return bc.checkNext(t, count, spent)
+}
+
+// checkNext
+// 送信者のまだブロックに入っていないトランザクションがcount件、合計spentの時に、tのnonceと残高が正しいか
+func (bc *Blockchain) checkNext(t *Transaction, count uint64, spent utils.Amount) error {
+	sender := t.senderBlockchainAddress
+	expected := bc.confirmedNonce(sender) + count
+	balance := bc.calculateTotalAmount(sender) - spent
+	if t.nonce < expected {
+		return fmt.Errorf("%w: got %d, next is %d", ErrNonceUsed, t.nonce, expected)
+	}
+	if t.nonce > expected {
+		return fmt.Errorf("%w: got %d, next is %d", ErrNonceTooHigh, t.nonce, expected)
+	}
+	if balance < t.value {
+		return fmt.Errorf("%w: balance %s, value %s", ErrInsufficientBalance, balance, t.value)
+	}
+	return nil
+}
+
+// confirmedNonce chainに入っている、そのアドレスから送金したトランザクションの数
+func (bc *Blockchain) confirmedNonce(blockchainAddress string) uint64 {
+	return bc.ledger.nonces[blockchainAddress]
+}
+
+// NextNonce 次に送金する時に使うnonce（transactionPoolにある分も含める）
+func (bc *Blockchain) NextNonce(blockchainAddress string) uint64 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	n := bc.confirmedNonce(blockchainAddress)
+	for _, t := range bc.transactionPool {
+		if t.senderBlockchainAddress == blockchainAddress {
+			n++
+		}
+	}
+	return n
+}
+
+// revalidateTransactionPool
+// chainが入れ替わった後に、nonceや残高が合わなくなったトランザクションを取り除く
+func (bc *Blockchain) revalidateTransactionPool() {
+	pool := make([]*Transaction, 0)
+	counts := make(map[string]uint64)
+	spent := make(map[string]utils.Amount)
+	for _, t := range bc.transactionPool {
+		sender := t.senderBlockchainAddress
+		if sender == MINING_SENDER {
+			continue
+		}
+		if err := bc.checkNext(t, counts[sender], spent[sender]); err != nil {
+			log.Printf("action=drop_transaction, error=%v", err)
+			continue
+		}
+		counts[sender]++
+		spent[sender] += t.value
+		pool = append(pool, t)
 	}
-	return false
+	bc.transactionPool = pool
 }
 
-// VerifyTransactionSignature node側がトランザクションの署名を検証
+// VerifyTransactionSignature
+// node側がトランザクションの署名を検証
+// IDが変えられないように、sが位数の半分より大きい署名は受け付けない
 func (bc *Blockchain) VerifyTransactionSignature(
 	senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction) bool {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
+	if senderPublicKey == nil || s == nil || !s.IsLowS(senderPublicKey.Curve) {
+		return false
+	}
+	h := utils.TransactionSigningHash(utils.CHAIN_ID,
+		t.senderBlockchainAddress, t.recipientBlockchainAddress, t.value, t.nonce)
 	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S)
 }
 
 func (bc *Blockchain) CopyTransactionPool() []*Transaction {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.copyTransactionPool()
+}
+
+func (bc *Blockchain) copyTransactionPool() []*Transaction {
 	transactions := make([]*Transaction, 0)
 	for _, t := range bc.transactionPool {
-		transactions = append(transactions,
-			NewTransaction(t.senderBlockchainAddress,
-				t.recipientBlockchainAddress,
-				t.value),
-		)
+		c := NewTransaction(t.senderBlockchainAddress,
+			t.recipientBlockchainAddress,
+			t.value,
+			t.nonce)
+		c.senderPublicKey = t.senderPublicKey
+		c.signature = t.signature
+		transactions = append(transactions, c)
 	}
 	return transactions
 }
 
+// ValidProof ハッシュの先頭にdifficultyビット以上0が並んでいるか
 func (bc *Blockchain) ValidProof(nouce int, previousHash [sha256.Size]byte, transactions []*Transaction, difficulty int) bool {
-	zeros := strings.Repeat("0", difficulty)
+	return validProof(nouce, previousHash, MerkleRoot(transactions), difficulty)
+}
+
+func validProof(nouce int, previousHash [sha256.Size]byte, merkleRoot [sha256.Size]byte, difficulty int) bool {
 	guessBlock := Block{
 		nonce:        nouce,
+		difficulty:   difficulty,
 		previousHash: previousHash,
+		merkleRoot:   merkleRoot,
 		timestamp:    0,
-		transactions: transactions,
 	}
-	gueesHashStr := fmt.Sprintf("%x", guessBlock.Hash())
-	return gueesHashStr[:difficulty] == zeros
+	return leadingZeroBits(guessBlock.Hash()) >= difficulty
 }
 
 // ProofOfWork
 // nouceを求める演算処理
 func (bc *Blockchain) ProofOfWork() int {
-	transactions := bc.CopyTransactionPool()
-	previousHash := bc.LastBlock().Hash()
+	bc.mux.RLock()
+	transactions := bc.copyTransactionPool()
+	previousHash := bc.lastBlock().Hash()
+	difficulty := bc.expectedDifficulty(bc.chain, len(bc.chain))
+	bc.mux.RUnlock()
+	return bc.proofOfWork(previousHash, transactions, difficulty)
+}
+
+func (bc *Blockchain) proofOfWork(previousHash [sha256.Size]byte, transactions []*Transaction, difficulty int) int {
+	merkleRoot := MerkleRoot(transactions) // ヘッダーだけを変えて試すので、根は1回だけ計算する
 	nonce := 0
-	for !bc.ValidProof(nonce, previousHash, transactions, MINING_DIFFICULTY) {
+	for !validProof(nonce, previousHash, merkleRoot, difficulty) {
 		nonce += 1
 	}
 	return nonce
 }
 
-func (bc *Blockchain) Mining() bool {
+// Mining
+// transactionPoolのスナップショットに対してnonceを求めるので、
+// マイニング中もトランザクションを受け付けられる
+// nonceを求めている間に他のブロックが繋がった場合はErrChainChanged
+func (bc *Blockchain) Mining() error {
+	bc.muxMining.Lock()
+	defer bc.muxMining.Unlock()
+
+	bc.mux.RLock()
+	transactions := bc.copyTransactionPool()
+	previousHash := bc.lastBlock().Hash()
+	difficulty := bc.expectedDifficulty(bc.chain, len(bc.chain))
+	height := uint64(len(bc.chain))
+	bc.mux.RUnlock()
+
 	// マイニングした人への報酬（この場合、publicKey,privateKeyは不要）
-	bc.AddTransaction(MINING_SENDER, bc.blockchainAddress, MINING_REWARD, nil, nil)
+	// 報酬のnonceはブロックの高さにして、ブロックごとにハッシュが変わるようにする
+	transactions = append(transactions, NewTransaction(MINING_SENDER, bc.blockchainAddress, MINING_REWARD, height))
+
+	nonce := bc.proofOfWork(previousHash, transactions, difficulty)
 
-	nonce := bc.ProofOfWork()
-	previousHash := bc.LastBlock().Hash()
-	bc.CreateBlock(nonce, previousHash)
+	bc.mux.Lock()
+	// nonceを求めている間に、隣のノードのブロックが繋がったかもしれない
+	if bc.lastBlock().Hash() != previousHash {
+		bc.mux.Unlock()
+		log.Printf("action=mining, status=fail, error=%v", ErrChainChanged)
+		return ErrChainChanged
+	}
+	b := NewBlock(nonce, previousHash, transactions, difficulty)
+	// 隣のノードのブロックは少し未来の時刻のことがあるので、その場合は1つ前のブロックより後の時刻にする
+	// （PoWのハッシュには時刻を含めないので、nonceはそのまま使える）
+	if last := bc.lastBlock().timestamp; b.timestamp <= last {
+		b.timestamp = last + 1
+	}
+	// 他のノードがAddBlockで行うのと同じ検証をして、受け付けられないブロックは繋げない
+	n := len(bc.chain)
+	if err := bc.validBlock(append(bc.chain[:n:n], b), n, bc.ledger, time.Now()); err != nil {
+		bc.mux.Unlock()
+		log.Printf("action=mining, status=fail, error=%v", err)
+		return err
+	}
+	if err := bc.appendBlock(b); err != nil {
+		bc.mux.Unlock()
+		log.Printf("action=mining, status=fail, error=%v", err)
+		return err
+	}
+	bc.removeFromTransactionPool(transactions)
+	bc.mux.Unlock()
 	log.Println("action=mining, status=success")
-	return true
+
+	// 隣のノードのchainにも同じブロックを繋げてもらう
+	bc.broadcastBlock(b)
+	return nil
 }
 
 // CalculateTotalAmount
 // 今持っているコインの合計を求める
-func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) float32 {
-	var totalAmount float32 = 0.0
-	for _, b := range bc.chain {
-		for _, t := range b.transactions {
-			value := t.value
-			if blockchainAddress == t.recipientBlockchainAddress {
-				totalAmount += value
-			}
-
-			if blockchainAddress == t.senderBlockchainAddress {
-				totalAmount -= value
-			}
-		}
-	}
-	return totalAmount
+func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) utils.Amount {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.calculateTotalAmount(blockchainAddress)
+}
+
+func (bc *Blockchain) calculateTotalAmount(blockchainAddress string) utils.Amount {
+	return bc.ledger.balances[blockchainAddress]
 }
 
 type Transaction struct {
 	senderBlockchainAddress    string
 	recipientBlockchainAddress string
-	value                      float32 // 送金する額
+	value                      utils.Amount     // 送金する額
+	nonce                      uint64           // 送信者ごとの通し番号。同じ署名の使い回しを防ぐ
+	senderPublicKey            *ecdsa.PublicKey // マイニングの報酬の場合はnil
+	signature                  *utils.Signature // マイニングの報酬の場合はnil
+}
+
+func NewTransaction(sender string, recipient string, value utils.Amount, nonce uint64) *Transaction {
+	return &Transaction{
+		senderBlockchainAddress:    sender,
+		recipientBlockchainAddress: recipient,
+		value:                      value,
+		nonce:                      nonce,
+	}
+}
+
+func (t *Transaction) SenderBlockchainAddress() string {
+	return t.senderBlockchainAddress
+}
+
+func (t *Transaction) RecipientBlockchainAddress() string {
+	return t.recipientBlockchainAddress
 }
 
-func NewTransaction(sender string, recipient string, value float32) *Transaction {
-	return &Transaction{sender, recipient, value}
+func (t *Transaction) Value() utils.Amount {
+	return t.value
 }
 
 func (t *Transaction) Print() {
 	fmt.Printf("%s\n", strings.Repeat("-", 40))
+	fmt.Printf(" id  %s\n", t.ID())
 	fmt.Printf(" sender_blockchain_address  %s\n", t.senderBlockchainAddress)
 	fmt.Printf(" recipient_blockchain_address  %s\n", t.recipientBlockchainAddress)
-	fmt.Printf(" value  %.1f\n", t.value)
+	fmt.Printf(" value  %s\n", t.value)
+	fmt.Printf(" nonce  %d\n", t.nonce)
+}
+
+// Hash 署名も含めたトランザクション全体のハッシュ（Merkle treeの葉）
+func (t *Transaction) Hash() [sha256.Size]byte {
+	m, _ := json.Marshal(t.signed())
+	return sha256.Sum256(m)
 }
 
+// ID トランザクションの識別子（Hashの16進数）
+func (t *Transaction) ID() string {
+	return fmt.Sprintf("%x", t.Hash())
+}
+
+// ParseTransactionID IDの文字列をHashの形に戻す
+func ParseTransactionID(id string) ([sha256.Size]byte, error) {
+	var h [sha256.Size]byte
+	b, err := hex.DecodeString(id)
+	if err != nil || len(b) != sha256.Size {
+		return h, fmt.Errorf("invalid transaction id %q", id)
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+func (t *Transaction) equal(o *Transaction) bool {
+	return t.senderBlockchainAddress == o.senderBlockchainAddress &&
+		t.recipientBlockchainAddress == o.recipientBlockchainAddress &&
+		t.value == o.value &&
+		t.nonce == o.nonce &&
+		signatureString(t.signature) == signatureString(o.signature)
+}
+
+// signed 署名も含めたトランザクション（IDはこの形から計算する）
+func (t *Transaction) signed() interface{} {
+	return struct {
+		Sender          string       `json:"sender_blockchain_address"`
+		Recipient       string       `json:"recipient_blockchain_address"`
+		Value           utils.Amount `json:"value"`
+		Nonce           uint64       `json:"nonce"`
+		SenderPublicKey string       `json:"sender_public_key,omitempty"`
+		Signature       string       `json:"signature,omitempty"`
+	}{
+		Sender:          t.senderBlockchainAddress,
+		Recipient:       t.recipientBlockchainAddress,
+		Value:           t.value,
+		Nonce:           t.nonce,
+		SenderPublicKey: publicKeyString(t.senderPublicKey),
+		Signature:       signatureString(t.signature),
+	}
+}
+
+// MarshalJSON 署名も含めたトランザクションに、IDを付けて返す
 func (t *Transaction) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Sender    string  `json:"sender_blockchain_address"`
-		Recipient string  `json:"recipient_blockchain_address"`
-		Value     float32 `json:"value"`
+		ID              string       `json:"id"`
+		Sender          string       `json:"sender_blockchain_address"`
+		Recipient       string       `json:"recipient_blockchain_address"`
+		Value           utils.Amount `json:"value"`
+		Nonce           uint64       `json:"nonce"`
+		SenderPublicKey string       `json:"sender_public_key,omitempty"`
+		Signature       string       `json:"signature,omitempty"`
 	}{
-		Sender:    t.senderBlockchainAddress,
-		Recipient: t.recipientBlockchainAddress,
-		Value:     t.value,
+		ID:              t.ID(),
+		Sender:          t.senderBlockchainAddress,
+		Recipient:       t.recipientBlockchainAddress,
+		Value:           t.value,
+		Nonce:           t.nonce,
+		SenderPublicKey: publicKeyString(t.senderPublicKey),
+		Signature:       signatureString(t.signature),
 	})
 }
 
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	v := struct {
+		Sender          *string       `json:"sender_blockchain_address"`
+		Recipient       *string       `json:"recipient_blockchain_address"`
+		Value           *utils.Amount `json:"value"`
+		Nonce           uint64        `json:"nonce"`
+		SenderPublicKey string        `json:"sender_public_key"`
+		Signature       string        `json:"signature"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Sender == nil || v.Recipient == nil || v.Value == nil {
+		return fmt.Errorf("transaction: missing field(s)")
+	}
+	t.senderBlockchainAddress = *v.Sender
+	t.recipientBlockchainAddress = *v.Recipient
+	t.value = *v.Value
+	t.nonce = v.Nonce
+	t.senderPublicKey = nil
+	t.signature = nil
+	if v.SenderPublicKey != "" {
+		pk, err := utils.PublicKeyFromString(v.SenderPublicKey)
+		if err != nil {
+			return err
+		}
+		t.senderPublicKey = pk
+	}
+	if v.Signature != "" {
+		s, err := utils.SignatureFromString(v.Signature)
+		if err != nil {
+			return err
+		}
+		t.signature = s
+	}
+	return nil
+}
+
+func publicKeyString(pk *ecdsa.PublicKey) string {
+	if pk == nil {
+		return ""
+	}
+	return utils.PublicKeyString(pk)
+}
+
+func signatureString(s *utils.Signature) string {
+	if s == nil {
+		return ""
+	}
+	return s.String()
+}
+
 type TransactionRequest struct {
-	SenderBlockchainAddress    *string  `json:"sender_blockchain_address"`
-	RecipientBlockchainAddress *string  `json:"recipient_blockchain_address"`
-	SenderPublicKey            *string  `json:"sender_public_key"`
-	Value                      *float32 `json:"value"`
-	Signature                  *string  `json:"signature"`
+	SenderBlockchainAddress    *string       `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress *string       `json:"recipient_blockchain_address"`
+	SenderPublicKey            *string       `json:"sender_public_key"`
+	Value                      *utils.Amount `json:"value"`
+	Nonce                      *uint64       `json:"nonce"`
+	Signature                  *string       `json:"signature"`
 }
 
 func (tr *TransactionRequest) Validate() bool {
@@ -265,8 +793,13 @@ func (tr *TransactionRequest) Validate() bool {
 		tr.RecipientBlockchainAddress == nil ||
 		tr.SenderPublicKey == nil ||
 		tr.Value == nil ||
+		tr.Nonce == nil ||
 		tr.Signature == nil {
 		return false
 	}
+	// 金額の形式(負の数・NaN・オーバーフロー)はutils.AmountのUnmarshalJSONで弾かれる
+	if *tr.Value <= 0 {
+		return false
+	}
 	return true
 }
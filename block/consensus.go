@@ -0,0 +1,138 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// resolveConflictsInBackground
+// 繋がらないブロックが続けて届いても、隣のノードのchainの取得は同時に1つだけ、
+// RESOLVE_CONFLICTS_MIN_INTERVALに1回までにする
+func (bc *Blockchain) resolveConflictsInBackground() {
+	if !atomic.CompareAndSwapInt32(&bc.resolving, 0, 1) {
+		return
+	}
+	// lastResolvedはresolvingを1にしたgoroutineだけが読み書きする
+	if time.Since(bc.lastResolved) < RESOLVE_CONFLICTS_MIN_INTERVAL {
+		atomic.StoreInt32(&bc.resolving, 0)
+		log.Println("action=resolve_conflicts, status=skipped")
+		return
+	}
+	bc.lastResolved = time.Now()
+	go func() {
+		defer atomic.StoreInt32(&bc.resolving, 0)
+		bc.ResolveConflicts()
+	}()
+}
+
+// ResolveConflicts
+// 隣のノードのchainを取得し、自分より計算量（chainWork）の合計が大きくて正しいchainがあれば、一番大きいものに置き換える
+// ブロックの数で比べると、difficultyを下げたchainを安く伸ばして置き換えられてしまう
+func (bc *Blockchain) ResolveConflicts() bool {
+	var bestChain []*Block
+	bc.mux.RLock()
+	maxWork := chainWork(bc.chain)
+	bc.mux.RUnlock()
+
+	for _, n := range bc.Neighbors() {
+		chain, err := fetchChain(n)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			continue
+		}
+		work := chainWork(chain)
+		if work.Cmp(maxWork) <= 0 {
+			continue
+		}
+		if err := bc.ValidChain(chain); err != nil {
+			log.Printf("ERROR: chain from %s: %v", n, err)
+			continue
+		}
+		maxWork = work
+		bestChain = chain
+	}
+
+	if bestChain == nil {
+		log.Println("action=resolve_conflicts, status=not_replaced")
+		return false
+	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	// 隣のノードに問い合わせている間に、自分のchainが伸びているかもしれない
+	if maxWork.Cmp(chainWork(bc.chain)) <= 0 {
+		log.Println("action=resolve_conflicts, status=not_replaced")
+		return false
+	}
+	if err := bc.replaceChain(bestChain); err != nil {
+		log.Printf("action=resolve_conflicts, status=fail, error=%v", err)
+		return false
+	}
+	log.Println("action=resolve_conflicts, status=replaced")
+	return true
+}
+
+// replaceChain
+// 分岐した地点より後ろの自分のブロックに入っていたトランザクションは、
+// 新しいchainに入っていなければtransactionPoolに戻す
+func (bc *Blockchain) replaceChain(chain []*Block) error {
+	fork := 0
+	for fork < len(bc.chain) && fork < len(chain) && bc.chain[fork].Hash() == chain[fork].Hash() {
+		fork++
+	}
+
+	if bc.storage != nil {
+		if err := bc.storage.Replace(chain); err != nil {
+			return err
+		}
+	}
+
+	pool := make([]*Transaction, 0)
+	for _, b := range bc.chain[fork:] {
+		for _, t := range b.transactions {
+			if t.senderBlockchainAddress != MINING_SENDER {
+				pool = append(pool, t)
+			}
+		}
+	}
+	pool = append(pool, bc.transactionPool...)
+
+	bc.chain = chain
+	bc.ledger = ledgerOf(chain)
+	bc.transactionPool = pool
+	for _, b := range chain[fork:] {
+		bc.removeFromTransactionPool(b.transactions)
+	}
+	bc.revalidateTransactionPool()
+	return nil
+}
+
+// fetchChain 隣のノードの GET / からchainを取得する
+func fetchChain(neighbor string) ([]*Block, error) {
+	endpoint := fmt.Sprintf("http://%s/", neighbor)
+	resp, err := neighborClient.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status=%d", endpoint, resp.StatusCode)
+	}
+	var bcResp struct {
+		Blocks []*Block `json:"chains"`
+	}
+	// 上限を超えたレスポンスは途中で切れるので、JSONの読み込みに失敗する
+	if err := json.NewDecoder(io.LimitReader(resp.Body, BLOCKCHAIN_MAX_CHAIN_BYTES)).Decode(&bcResp); err != nil {
+		return nil, fmt.Errorf("GET %s: %v", endpoint, err)
+	}
+	for i, b := range bcResp.Blocks {
+		if b == nil {
+			return nil, fmt.Errorf("GET %s: block %d is null", endpoint, i)
+		}
+	}
+	return bcResp.Blocks, nil
+}
@@ -0,0 +1,86 @@
+package block
+
+import (
+	"crypto/sha256"
+	"go_blockchain/utils"
+	"math/big"
+	"math/bits"
+	"time"
+)
+
+const (
+	MIN_DIFFICULTY                 = 1               // difficultyの下限（先頭の0のビット数）
+	MAX_DIFFICULTY                 = sha256.Size * 8 // difficultyの上限
+	DIFFICULTY_ADJUSTMENT_INTERVAL = 10              // 何ブロックごとにdifficultyを見直すか（目標時間はutils.TARGET_BLOCK_TIME）
+)
+
+// NextDifficulty 次にマイニングするブロックのdifficulty
+func (bc *Blockchain) NextDifficulty() int {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.expectedDifficulty(bc.chain, len(bc.chain))
+}
+
+// expectedDifficulty
+// chainのheight番目のブロックが満たすべきdifficulty
+// DIFFICULTY_ADJUSTMENT_INTERVALブロックごとに、直前の区間にかかった時間と目標時間を比べて
+// 2倍以上速ければ1ビット難しく、2倍以上遅ければ1ビット易しくする
+func (bc *Blockchain) expectedDifficulty(chain []*Block, height int) int {
+	if height <= 1 {
+		return MINING_DIFFICULTY // 1個目のブロックの次は初期値
+	}
+	previous := chain[height-1].difficulty
+	if height%DIFFICULTY_ADJUSTMENT_INTERVAL != 0 || height <= DIFFICULTY_ADJUSTMENT_INTERVAL {
+		return previous
+	}
+
+	first := chain[height-1-DIFFICULTY_ADJUSTMENT_INTERVAL]
+	last := chain[height-1]
+	actual := time.Duration(last.timestamp - first.timestamp)
+	expected := utils.TARGET_BLOCK_TIME * DIFFICULTY_ADJUSTMENT_INTERVAL
+
+	difficulty := previous
+	switch {
+	case actual < expected/2:
+		difficulty++
+	case actual > expected*2:
+		difficulty--
+	}
+	if difficulty < MIN_DIFFICULTY {
+		difficulty = MIN_DIFFICULTY
+	}
+	if difficulty > MAX_DIFFICULTY {
+		difficulty = MAX_DIFFICULTY
+	}
+	return difficulty
+}
+
+// chainWork
+// chainを作るのに必要な計算量の合計（ブロックごとに2^difficulty）
+// 検証前の隣のノードのchainにも使うので、範囲外のdifficultyは範囲内に収めて計算する
+func chainWork(chain []*Block) *big.Int {
+	work := new(big.Int)
+	for _, b := range chain {
+		d := b.difficulty
+		if d < 0 {
+			d = 0
+		}
+		if d > MAX_DIFFICULTY {
+			d = MAX_DIFFICULTY
+		}
+		work.Add(work, new(big.Int).Lsh(big.NewInt(1), uint(d)))
+	}
+	return work
+}
+
+// leadingZeroBits ハッシュの先頭に0のビットがいくつ並んでいるか
+func leadingZeroBits(h [sha256.Size]byte) int {
+	n := 0
+	for _, b := range h {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,36 @@
+package block
+
+import "go_blockchain/utils"
+
+// ledger
+// chainの最後のブロックまでを反映した、アドレスごとの残高と送金したトランザクションの数（次のnonce）
+// ブロックが届くたびにchain全体を数え直さないように、appendBlockとreplaceChainで最新に保つ
+type ledger struct {
+	balances map[string]utils.Amount
+	nonces   map[string]uint64
+}
+
+func newLedger() *ledger {
+	return &ledger{
+		balances: make(map[string]utils.Amount),
+		nonces:   make(map[string]uint64),
+	}
+}
+
+// ledgerOf chainのすべてのブロックを反映したledger（検証済みのchainに使う）
+func ledgerOf(chain []*Block) *ledger {
+	l := newLedger()
+	for _, b := range chain {
+		l.apply(b)
+	}
+	return l
+}
+
+// apply ブロックのトランザクションを反映する
+func (l *ledger) apply(b *Block) {
+	for _, t := range b.transactions {
+		l.nonces[t.senderBlockchainAddress]++
+		l.balances[t.senderBlockchainAddress] -= t.value
+		l.balances[t.recipientBlockchainAddress] += t.value
+	}
+}
@@ -0,0 +1,252 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// merkleNodePrefix 葉(トランザクションのハッシュ)と内部ノードのハッシュを区別する
+const merkleNodePrefix = 0x01
+
+// MerkleRoot
+// トランザクションのハッシュを葉にしたMerkle treeの根
+// 要素が奇数の段では、最後の要素をそのまま上の段に上げる
+func MerkleRoot(transactions []*Transaction) [sha256.Size]byte {
+	if len(transactions) == 0 {
+		return [sha256.Size]byte{}
+	}
+	level := make([][sha256.Size]byte, len(transactions))
+	for i, t := range transactions {
+		level[i] = t.Hash()
+	}
+	for len(level) > 1 {
+		level = merkleParentLevel(level)
+	}
+	return level[0]
+}
+
+func merkleParentLevel(level [][sha256.Size]byte) [][sha256.Size]byte {
+	parents := make([][sha256.Size]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			parents = append(parents, level[i])
+			continue
+		}
+		parents = append(parents, merkleNodeHash(level[i], level[i+1]))
+	}
+	return parents
+}
+
+func merkleNodeHash(left [sha256.Size]byte, right [sha256.Size]byte) [sha256.Size]byte {
+	buf := make([]byte, 0, 1+2*sha256.Size)
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return sha256.Sum256(buf)
+}
+
+// MerkleStep 根までの途中で組み合わせる兄弟ノード
+type MerkleStep struct {
+	Hash [sha256.Size]byte
+	Left bool // 兄弟ノードが左側にある場合はtrue
+}
+
+func (s MerkleStep) MarshalJSON() ([]byte, error) {
+	position := "right"
+	if s.Left {
+		position = "left"
+	}
+	return json.Marshal(struct {
+		Hash     string `json:"hash"`
+		Position string `json:"position"`
+	}{
+		Hash:     fmt.Sprintf("%x", s.Hash),
+		Position: position,
+	})
+}
+
+func (s *MerkleStep) UnmarshalJSON(data []byte) error {
+	v := struct {
+		Hash     string `json:"hash"`
+		Position string `json:"position"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	h, err := hex.DecodeString(v.Hash)
+	if err != nil || len(h) != sha256.Size {
+		return fmt.Errorf("merkle: invalid hash %q", v.Hash)
+	}
+	switch v.Position {
+	case "left":
+		s.Left = true
+	case "right":
+		s.Left = false
+	default:
+		return fmt.Errorf("merkle: invalid position %q", v.Position)
+	}
+	copy(s.Hash[:], h)
+	return nil
+}
+
+// MerkleBranch index番目のトランザクションから根までの兄弟ノードを返す
+func MerkleBranch(transactions []*Transaction, index int) ([]MerkleStep, error) {
+	if index < 0 || index >= len(transactions) {
+		return nil, fmt.Errorf("merkle: index %d out of range", index)
+	}
+	level := make([][sha256.Size]byte, len(transactions))
+	for i, t := range transactions {
+		level[i] = t.Hash()
+	}
+	steps := make([]MerkleStep, 0)
+	for len(level) > 1 {
+		if index%2 == 1 {
+			steps = append(steps, MerkleStep{Hash: level[index-1], Left: true})
+		} else if index+1 < len(level) {
+			steps = append(steps, MerkleStep{Hash: level[index+1], Left: false})
+		} // 兄弟がいない場合はそのまま上に上がる
+		level = merkleParentLevel(level)
+		index /= 2
+	}
+	return steps, nil
+}
+
+// VerifyMerkleProof トランザクションのハッシュと兄弟ノードから根を計算し、rootと一致するか
+func VerifyMerkleProof(txHash [sha256.Size]byte, root [sha256.Size]byte, steps []MerkleStep) bool {
+	h := txHash
+	for _, s := range steps {
+		if s.Left {
+			h = merkleNodeHash(s.Hash, h)
+		} else {
+			h = merkleNodeHash(h, s.Hash)
+		}
+	}
+	return h == root
+}
+
+// MerkleProof
+// トランザクションがブロックに含まれていることの証明
+// ブロックのヘッダーも含めるので、MerkleRootがBlockHashのブロックのものであることまで確かめられる
+type MerkleProof struct {
+	TransactionHash [sha256.Size]byte
+	BlockHeight     int
+	BlockHash       [sha256.Size]byte
+	Timestamp       int64 // ここからMerkleRootまでがブロックのヘッダー
+	Nonce           int
+	Difficulty      int
+	PreviousHash    [sha256.Size]byte
+	MerkleRoot      [sha256.Size]byte
+	Steps           []MerkleStep
+}
+
+// NewMerkleProof chainのheight番目のブロックbの、index番目のトランザクションの証明
+func NewMerkleProof(b *Block, height int, index int) (*MerkleProof, error) {
+	steps, err := MerkleBranch(b.transactions, index)
+	if err != nil {
+		return nil, err
+	}
+	return &MerkleProof{
+		TransactionHash: b.transactions[index].Hash(),
+		BlockHeight:     height,
+		BlockHash:       b.Hash(),
+		Timestamp:       b.timestamp,
+		Nonce:           b.nonce,
+		Difficulty:      b.difficulty,
+		PreviousHash:    b.previousHash,
+		MerkleRoot:      b.merkleRoot,
+		Steps:           steps,
+	}, nil
+}
+
+// HeaderHash ヘッダーからBlock.Hashを計算し直す
+func (p *MerkleProof) HeaderHash() [sha256.Size]byte {
+	header := Block{
+		timestamp:    p.Timestamp,
+		nonce:        p.Nonce,
+		difficulty:   p.Difficulty,
+		previousHash: p.PreviousHash,
+		merkleRoot:   p.MerkleRoot,
+	}
+	return header.Hash()
+}
+
+// Verify
+// 次のすべてを満たすか
+//   - トランザクションのハッシュと兄弟ノードから、MerkleRootが計算できる
+//   - ヘッダー（MerkleRootを含む）のハッシュがBlockHashと一致する
+//   - ヘッダーのnonceがdifficultyを満たす
+//
+// BlockHashが正しいchainのブロックかどうかは、別のノードの GET /blocks/{height} などと比べて確かめる
+func (p *MerkleProof) Verify() bool {
+	return VerifyMerkleProof(p.TransactionHash, p.MerkleRoot, p.Steps) &&
+		p.HeaderHash() == p.BlockHash &&
+		validProof(p.Nonce, p.PreviousHash, p.MerkleRoot, p.Difficulty)
+}
+
+func (p *MerkleProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TransactionID string       `json:"transaction_id"`
+		BlockHeight   int          `json:"block_height"`
+		BlockHash     string       `json:"block_hash"`
+		Timestamp     int64        `json:"timestamp"`
+		Nonce         int          `json:"nonce"`
+		Difficulty    int          `json:"difficulty"`
+		PreviousHash  string       `json:"previous_hash"`
+		MerkleRoot    string       `json:"merkle_root"`
+		Proof         []MerkleStep `json:"proof"`
+	}{
+		TransactionID: fmt.Sprintf("%x", p.TransactionHash),
+		BlockHeight:   p.BlockHeight,
+		BlockHash:     fmt.Sprintf("%x", p.BlockHash),
+		Timestamp:     p.Timestamp,
+		Nonce:         p.Nonce,
+		Difficulty:    p.Difficulty,
+		PreviousHash:  fmt.Sprintf("%x", p.PreviousHash),
+		MerkleRoot:    fmt.Sprintf("%x", p.MerkleRoot),
+		Proof:         p.Steps,
+	})
+}
+
+func (p *MerkleProof) UnmarshalJSON(data []byte) error {
+	v := struct {
+		TransactionID string       `json:"transaction_id"`
+		BlockHeight   int          `json:"block_height"`
+		BlockHash     string       `json:"block_hash"`
+		Timestamp     *int64       `json:"timestamp"`
+		Nonce         *int         `json:"nonce"`
+		Difficulty    int          `json:"difficulty"`
+		PreviousHash  string       `json:"previous_hash"`
+		MerkleRoot    string       `json:"merkle_root"`
+		Proof         []MerkleStep `json:"proof"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Timestamp == nil || v.Nonce == nil {
+		return fmt.Errorf("merkle: missing block header field(s)")
+	}
+	for _, f := range []struct {
+		name string
+		hex  string
+		dst  *[sha256.Size]byte
+	}{
+		{"transaction_id", v.TransactionID, &p.TransactionHash},
+		{"block_hash", v.BlockHash, &p.BlockHash},
+		{"previous_hash", v.PreviousHash, &p.PreviousHash},
+		{"merkle_root", v.MerkleRoot, &p.MerkleRoot},
+	} {
+		h, err := hex.DecodeString(f.hex)
+		if err != nil || len(h) != sha256.Size {
+			return fmt.Errorf("merkle: invalid %s %q", f.name, f.hex)
+		}
+		copy(f.dst[:], h)
+	}
+	p.BlockHeight = v.BlockHeight
+	p.Timestamp = *v.Timestamp
+	p.Nonce = *v.Nonce
+	p.Difficulty = v.Difficulty
+	p.Steps = v.Proof
+	return nil
+}
@@ -0,0 +1,43 @@
+package block
+
+import (
+	"go_blockchain/wallet"
+	"testing"
+	"time"
+)
+
+// TestMiningAfterFutureBlock
+// 隣のノードから少し未来の時刻のブロックを受け取った直後でも、
+// マイニングしたブロックが1つ前のブロックより後の時刻になり、chainが正しいままであることを確かめる
+func TestMiningAfterFutureBlock(t *testing.T) {
+	miner := wallet.NewWallet().BlockchainAddress()
+	bc, err := NewBlockchain(miner, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 30秒先の時刻の、隣のノードがマイニングしたブロック
+	peer := wallet.NewWallet().BlockchainAddress()
+	previousHash := bc.LastBlock().Hash()
+	difficulty := bc.NextDifficulty()
+	transactions := []*Transaction{NewTransaction(MINING_SENDER, peer, MINING_REWARD, 1)}
+	b := NewBlock(bc.proofOfWork(previousHash, transactions, difficulty), previousHash, transactions, difficulty)
+	b.timestamp = time.Now().Add(30 * time.Second).UnixNano()
+	if err := bc.AddBlock(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bc.Mining(); err != nil {
+		t.Fatalf("Mining: %v", err)
+	}
+	chain := bc.Chain()
+	if len(chain) != 3 {
+		t.Fatalf("chain length = %d, want 3", len(chain))
+	}
+	if chain[2].timestamp <= b.timestamp {
+		t.Errorf("mined block timestamp %d is not after the parent %d", chain[2].timestamp, b.timestamp)
+	}
+	if err := bc.ValidChain(chain); err != nil {
+		t.Errorf("ValidChain: %v", err)
+	}
+}
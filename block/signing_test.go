@@ -0,0 +1,48 @@
+package block_test
+
+import (
+	"crypto/elliptic"
+	"go_blockchain/block"
+	"go_blockchain/utils"
+	"go_blockchain/wallet"
+	"testing"
+)
+
+// TestWalletSignatureVerifiedByNode
+// walletのGenerateSignatureで作った署名を、ブロックチェーンサーバーのVerifyTransactionSignatureが受け付けることを確かめる
+// 署名した内容と違うトランザクションは受け付けない
+func TestWalletSignatureVerifiedByNode(t *testing.T) {
+	bc, err := block.NewBlockchain("", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := wallet.NewWallet().BlockchainAddress()
+	value, _ := utils.ParseAmount("1.5")
+
+	for _, curve := range []elliptic.Curve{elliptic.P256(), utils.Secp256k1()} {
+		w := wallet.NewWalletWithCurve(curve)
+		sender := w.BlockchainAddress()
+		signature := wallet.NewTransaction(w.PrivateKey(), w.PublicKey(), sender, recipient, value, 7).GenerateSignature()
+
+		// 文字列にして送った後の形で検証する
+		publicKey, err := utils.PublicKeyFromString(w.PublicKeyStr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := utils.SignatureFromString(signature.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := curve.Params().Name
+		if !bc.VerifyTransactionSignature(publicKey, s, block.NewTransaction(sender, recipient, value, 7)) {
+			t.Errorf("%s: signature from wallet was rejected", name)
+		}
+		if bc.VerifyTransactionSignature(publicKey, s, block.NewTransaction(sender, recipient, value, 8)) {
+			t.Errorf("%s: signature accepted for a different nonce", name)
+		}
+		if bc.VerifyTransactionSignature(publicKey, s, block.NewTransaction(sender, recipient, value+1, 7)) {
+			t.Errorf("%s: signature accepted for a different value", name)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package block
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const blockLogFileName = "blocks.jsonl"
+
+// Storage ブロックの永続化先
+// Loadで保存済みのブロックを古い順に返し、Appendで新しいブロックを追記する
+// Replaceはchain全体を入れ替える（他のノードのchainを採用した時に使う）
+type Storage interface {
+	Load() ([]*Block, error)
+	Append(b *Block) error
+	Replace(blocks []*Block) error
+}
+
+// FileStorage ブロックを1行1ブロックのJSONで追記していくログファイル
+type FileStorage struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileStorage(dataDir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStorage{path: filepath.Join(dataDir, blockLogFileName)}, nil
+}
+
+func (fs *FileStorage) Path() string {
+	return fs.path
+}
+
+// Load
+// 書き込み途中でクラッシュした場合、最後の行が壊れているので、
+// 最後に正しく読めた行の末尾までファイルを切り詰める
+// 途中の行が壊れている場合は、データを失わないようにエラーを返す
+func (fs *FileStorage) Load() ([]*Block, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, err := os.OpenFile(fs.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var blocks []*Block
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break // 改行で終わっていない行は書き込み途中
+		}
+		if err != nil {
+			return nil, err
+		}
+		b := new(Block)
+		if err := json.Unmarshal(bytes.TrimSpace(line), b); err != nil {
+			if _, perr := r.Peek(1); perr != io.EOF {
+				return nil, fmt.Errorf("storage: corrupted block %d in %s: %v", len(blocks), fs.path, err)
+			}
+			break
+		}
+		blocks = append(blocks, b)
+		offset += int64(len(line))
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != offset {
+		if err := f.Truncate(offset); err != nil {
+			return nil, err
+		}
+		if err := f.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// Append
+// 1行分をまとめて書き込み、fsyncが終わってから成功を返す
+// 書き込みに失敗した場合（ディスクが一杯など）は、書きかけの行が次の行の前に残らないように、
+// 書き込む前の長さまでファイルを切り詰める
+func (fs *FileStorage) Append(b *Block) error {
+	m, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	m = append(m, '\n')
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if _, err := f.Write(m); err != nil {
+		return rollbackAppend(f, size, err)
+	}
+	if err := f.Sync(); err != nil {
+		return rollbackAppend(f, size, err)
+	}
+	return f.Close()
+}
+
+// rollbackAppend Appendで書きかけた分を取り除き、元のエラーを返す
+func rollbackAppend(f *os.File, size int64, err error) error {
+	if terr := f.Truncate(size); terr != nil {
+		return fmt.Errorf("%v (truncate: %v)", err, terr)
+	}
+	f.Sync()
+	return err
+}
+
+// Replace
+// 一時ファイルに全ブロックを書いてから rename するので、途中でクラッシュしても
+// 古いchainか新しいchainのどちらかが残る
+func (fs *FileStorage) Replace(blocks []*Block) error {
+	var buf bytes.Buffer
+	for _, b := range blocks {
+		m, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		buf.Write(m)
+		buf.WriteByte('\n')
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	tmp := fs.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fs.path); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(fs.path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
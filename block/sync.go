@@ -0,0 +1,199 @@
+package block
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_blockchain/utils"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	BLOCKCHAIN_HOST                  = "127.0.0.1"
+	BLOCKCHAIN_PORT_RANGE_START      = 5001 // 隣のノードを探すポートの範囲
+	BLOCKCHAIN_PORT_RANGE_END        = 5004
+	BLOCKCHAIN_NEIGHBOR_SYNC_TIME    = 20 * time.Second // 隣のノードを探し直す間隔
+	BLOCKCHAIN_NEIGHBOR_HTTP_TIMEOUT = 3 * time.Second
+	BLOCKCHAIN_MAX_CHAIN_BYTES       = 128 << 20        // 隣のノードの GET / のレスポンスの上限
+	RESOLVE_CONFLICTS_MIN_INTERVAL   = 10 * time.Second // 繋がらないブロックでResolveConflictsする間隔の下限
+)
+
+var neighborClient = &http.Client{Timeout: BLOCKCHAIN_NEIGHBOR_HTTP_TIMEOUT}
+
+// AddBlockで繋げなかった理由
+var (
+	ErrPreviousHash = errors.New("previous_hash does not match the last block")
+	ErrKnownBlock   = errors.New("block is already in the chain")
+	ErrNilBlock     = errors.New("block is null")
+)
+
+// SetStaticNeighbors
+// 隣のノードを"host:port"のリストで固定する。空の場合はポートの範囲を走査して探す
+func (bc *Blockchain) SetStaticNeighbors(neighbors []string) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.staticNeighbors = append([]string{}, neighbors...)
+}
+
+func (bc *Blockchain) Neighbors() []string {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	return append([]string{}, bc.neighbors...)
+}
+
+// SyncNeighbors 隣のノードの一覧を更新する
+func (bc *Blockchain) SyncNeighbors() {
+	bc.muxNeighbors.Lock()
+	static := bc.staticNeighbors
+	bc.muxNeighbors.Unlock()
+
+	var neighbors []string
+	if len(static) > 0 {
+		neighbors = append([]string{}, static...)
+	} else {
+		neighbors = utils.FindNeighbors(BLOCKCHAIN_HOST, bc.port,
+			BLOCKCHAIN_PORT_RANGE_START, BLOCKCHAIN_PORT_RANGE_END)
+	}
+
+	bc.muxNeighbors.Lock()
+	bc.neighbors = neighbors
+	bc.muxNeighbors.Unlock()
+	log.Printf("action=sync_neighbors, neighbors=%v", neighbors)
+}
+
+// StartSyncNeighbors 一定間隔で隣のノードを探し直す
+func (bc *Blockchain) StartSyncNeighbors() {
+	bc.SyncNeighbors()
+	time.AfterFunc(BLOCKCHAIN_NEIGHBOR_SYNC_TIME, bc.StartSyncNeighbors)
+}
+
+// broadcastTransaction
+// 自分のtransactionPoolに入ったトランザクションを、隣のノードのtransactionPoolにも入れる
+// 受け取った側も新しいトランザクションであれば、さらに隣のノードに送る
+func (bc *Blockchain) broadcastTransaction(t *Transaction, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) {
+	publicKeyStr := publicKeyString(senderPublicKey)
+	signatureStr := signatureString(s)
+	tr := &TransactionRequest{
+		SenderBlockchainAddress:    &t.senderBlockchainAddress,
+		RecipientBlockchainAddress: &t.recipientBlockchainAddress,
+		SenderPublicKey:            &publicKeyStr,
+		Value:                      &t.value,
+		Nonce:                      &t.nonce,
+		Signature:                  &signatureStr,
+	}
+	m, _ := json.Marshal(tr)
+	for _, n := range bc.Neighbors() {
+		go bc.sendToNeighbor(http.MethodPut, fmt.Sprintf("http://%s/transactions", n), m)
+	}
+}
+
+// broadcastBlock マイニングした、または隣のノードから届いた新しいブロックを隣のノードに送る
+func (bc *Blockchain) broadcastBlock(b *Block) {
+	m, _ := json.Marshal(b)
+	for _, n := range bc.Neighbors() {
+		go bc.sendToNeighbor(http.MethodPost, fmt.Sprintf("http://%s/blocks", n), m)
+	}
+}
+
+// sendToNeighbor
+// 遅い隣のノードを待たないように、goroutineで呼び出す
+func (bc *Blockchain) sendToNeighbor(method string, endpoint string, body []byte) {
+	req, _ := http.NewRequest(method, endpoint, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := neighborClient.Do(req)
+	if err != nil {
+		log.Printf("ERROR: %s %s: %v", method, endpoint, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("ERROR: %s %s: status=%d", method, endpoint, resp.StatusCode)
+	}
+}
+
+// ReceiveBlock
+// 隣のノードから届いたブロックを繋げ、新しいブロックであれば他の隣のノードにも送る
+// 自分のchainの最後に繋がらない場合は、隣のノードのchainの方が進んでいるかもしれないので、
+// バックグラウンドでResolveConflictsする
+// 誰でもPOST /blocksできるので、マイニングの計算をしていないブロックでは隣のノードのchainを取りに行かない
+func (bc *Blockchain) ReceiveBlock(b *Block) error {
+	err := bc.AddBlock(b)
+	switch {
+	case err == nil:
+		bc.broadcastBlock(b)
+	case errors.Is(err, ErrPreviousHash):
+		if bc.worthResolving(b) {
+			bc.resolveConflictsInBackground()
+		}
+	}
+	return err
+}
+
+// worthResolving
+// bが自分の次のブロックと同程度（1ビット低いところまで）のdifficultyを満たしているか
+// difficultyは調整のたびに1ビットずつしか変わらないので、同じ程度の高さの分岐であればこれを満たす
+func (bc *Blockchain) worthResolving(b *Block) bool {
+	if b.difficulty < bc.NextDifficulty()-1 {
+		return false
+	}
+	return validProof(b.nonce, b.previousHash, b.merkleRoot, b.difficulty)
+}
+
+// AddBlock
+// 隣のノードがマイニングしたブロックを、自分のchainの最後に繋げる
+// 取り込まれたトランザクションはtransactionPoolから取り除く
+// すでにchainにあるブロックの場合はErrKnownBlock、最後のブロックに繋がらない場合はErrPreviousHash
+func (bc *Blockchain) AddBlock(b *Block) error {
+	if b == nil {
+		return ErrNilBlock
+	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	if b.previousHash != bc.lastBlock().Hash() {
+		if bc.hasBlock(b.Hash()) {
+			return ErrKnownBlock
+		}
+		return fmt.Errorf("%w: %x", ErrPreviousHash, b.previousHash)
+	}
+	// 自分のchainは検証済みなので、新しいブロックだけを署名・残高・nonceも含めて確かめる
+	n := len(bc.chain)
+	if err := bc.validBlock(append(bc.chain[:n:n], b), n, bc.ledger, time.Now()); err != nil {
+		return err
+	}
+	if err := bc.appendBlock(b); err != nil {
+		return err
+	}
+	bc.removeFromTransactionPool(b.transactions)
+	bc.revalidateTransactionPool()
+	log.Println("action=add_block, status=success")
+	return nil
+}
+
+// hasBlock 隣のノードから同じブロックが何度も届くので、新しい方から探す
+func (bc *Blockchain) hasBlock(hash [sha256.Size]byte) bool {
+	for i := len(bc.chain) - 1; i >= 0; i-- {
+		if bc.chain[i].Hash() == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func (bc *Blockchain) removeFromTransactionPool(transactions []*Transaction) {
+	pool := bc.transactionPool
+	for _, t := range transactions {
+		for i, p := range pool {
+			if p.equal(t) {
+				pool = append(pool[:i:i], pool[i+1:]...)
+				break
+			}
+		}
+	}
+	bc.transactionPool = pool
+}
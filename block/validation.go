@@ -0,0 +1,157 @@
+package block
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"go_blockchain/utils"
+	"time"
+)
+
+// ValidChainの検証に失敗した理由
+const (
+	REASON_EMPTY_CHAIN         = "empty chain"
+	REASON_GENESIS             = "genesis block does not match"
+	REASON_NULL_BLOCK          = "block is null"
+	REASON_NULL_TRANSACTION    = "transaction is null"
+	REASON_PREVIOUS_HASH       = "previous_hash does not match the previous block"
+	REASON_DIFFICULTY          = "difficulty does not match the expected difficulty"
+	REASON_PROOF_OF_WORK       = "nonce does not meet the difficulty"
+	REASON_MERKLE_ROOT         = "merkle_root does not match the transactions"
+	REASON_TIMESTAMP           = "timestamp is not after the previous block"
+	REASON_TIMESTAMP_FUTURE    = "timestamp is too far in the future"
+	REASON_ADDRESS             = "invalid blockchain address"
+	REASON_SIGNATURE           = "invalid transaction signature"
+	REASON_SENDER_ADDRESS      = "sender address does not match the sender public key"
+	REASON_VALUE               = "transaction value must be positive"
+	REASON_BALANCE             = "not enough balance"
+	REASON_NONCE               = "unexpected transaction nonce"
+	REASON_MINING_REWARD       = "block must have exactly one mining reward"
+	REASON_MINING_REWARD_VALUE = "invalid mining reward value or nonce"
+)
+
+// ChainError 最初に見つかった不正なブロックと、その理由
+type ChainError struct {
+	Height      int               // chainの何番目のブロックか
+	Hash        [sha256.Size]byte // 不正なブロックのハッシュ
+	Transaction int               // トランザクションが原因の場合はその位置、それ以外は-1
+	Reason      string
+}
+
+func (e *ChainError) Error() string {
+	if e.Transaction >= 0 {
+		return fmt.Sprintf("invalid block %d (%x) transaction %d: %s", e.Height, e.Hash, e.Transaction, e.Reason)
+	}
+	return fmt.Sprintf("invalid block %d (%x): %s", e.Height, e.Hash, e.Reason)
+}
+
+// ValidChain
+// chainの先頭から順に、ハッシュの繋がり・difficulty・nonce・タイムスタンプ・署名・アドレス・残高・報酬を検証する
+// 1個目のブロックはGenesisBlockと同じであることだけを確かめる（別の1個目から始まるchainは受け付けない）
+func (bc *Blockchain) ValidChain(chain []*Block) error {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.validChain(chain)
+}
+
+func (bc *Blockchain) validChain(chain []*Block) error {
+	if len(chain) == 0 {
+		return &ChainError{Height: 0, Transaction: -1, Reason: REASON_EMPTY_CHAIN}
+	}
+	now := time.Now()
+	l := newLedger()
+	for i := range chain {
+		if err := bc.validBlock(chain, i, l, now); err != nil {
+			return err
+		}
+		l.apply(chain[i])
+	}
+	return nil
+}
+
+// validBlock
+// chain[:i]が正しく、lがchain[:i]を反映している前提で、chain[i]だけを検証する（lは変更しない）
+// AddBlockでは自分のchainとledgerを使い、chain全体を数え直さずに新しいブロックだけを確かめる
+func (bc *Blockchain) validBlock(chain []*Block, i int, l *ledger, now time.Time) error {
+	b := chain[i]
+	if b == nil {
+		return &ChainError{Height: i, Transaction: -1, Reason: REASON_NULL_BLOCK}
+	}
+	blockErr := func(reason string, tx int) error {
+		return &ChainError{Height: i, Hash: b.Hash(), Transaction: tx, Reason: reason}
+	}
+
+	// MerkleRootはトランザクションのハッシュを計算するので、先にnullがないことを確かめる
+	for j, t := range b.transactions {
+		if t == nil {
+			return blockErr(REASON_NULL_TRANSACTION, j)
+		}
+	}
+	if i == 0 {
+		if b.Hash() != GenesisBlock().Hash() || len(b.transactions) != 0 {
+			return blockErr(REASON_GENESIS, -1)
+		}
+		return nil
+	}
+	if b.merkleRoot != MerkleRoot(b.transactions) {
+		return blockErr(REASON_MERKLE_ROOT, -1)
+	}
+	preBlock := chain[i-1]
+	if b.previousHash != preBlock.Hash() {
+		return blockErr(REASON_PREVIOUS_HASH, -1)
+	}
+	if b.difficulty != bc.expectedDifficulty(chain, i) {
+		return blockErr(REASON_DIFFICULTY, -1)
+	}
+	if !validProof(b.nonce, b.previousHash, b.merkleRoot, b.difficulty) {
+		return blockErr(REASON_PROOF_OF_WORK, -1)
+	}
+	if b.timestamp <= preBlock.timestamp {
+		return blockErr(REASON_TIMESTAMP, -1)
+	}
+	if time.Unix(0, b.timestamp).Sub(now) > utils.MAX_FUTURE_BLOCK_TIME {
+		return blockErr(REASON_TIMESTAMP_FUTURE, -1)
+	}
+
+	// このブロックの中での増減（lに足して使う）
+	balances := make(map[string]utils.Amount)
+	nonces := make(map[string]uint64)
+	rewards := 0
+	for j, t := range b.transactions {
+		sender := t.senderBlockchainAddress
+		if utils.ValidateAddress(t.recipientBlockchainAddress) != nil {
+			return blockErr(REASON_ADDRESS, j)
+		}
+		if sender == MINING_SENDER {
+			rewards++
+			if t.value != MINING_REWARD || t.nonce != uint64(i) {
+				return blockErr(REASON_MINING_REWARD_VALUE, j)
+			}
+		} else {
+			if t.value <= 0 {
+				return blockErr(REASON_VALUE, j)
+			}
+			if utils.ValidateAddress(sender) != nil {
+				return blockErr(REASON_ADDRESS, j)
+			}
+			if !bc.VerifyTransactionSignature(t.senderPublicKey, t.signature, t) {
+				return blockErr(REASON_SIGNATURE, j)
+			}
+			if utils.AddressFromPublicKey(t.senderPublicKey) != sender {
+				return blockErr(REASON_SENDER_ADDRESS, j)
+			}
+			if t.nonce != l.nonces[sender]+nonces[sender] {
+				return blockErr(REASON_NONCE, j)
+			}
+			if l.balances[sender]+balances[sender] < t.value {
+				return blockErr(REASON_BALANCE, j)
+			}
+		}
+		nonces[sender]++
+		balances[sender] -= t.value
+		balances[t.recipientBlockchainAddress] += t.value
+	}
+	if rewards != 1 {
+		return blockErr(REASON_MINING_REWARD, -1)
+	}
+	return nil
+}
@@ -1,59 +1,139 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"go_blockchain/block"
 	"go_blockchain/utils"
 	"go_blockchain/wallet"
-	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ADDRESS_TRANSACTIONS_DEFAULT_LIMIT = 20
+	ADDRESS_TRANSACTIONS_MAX_LIMIT     = 100
+	BLOCKS_DEFAULT_LIMIT               = 20
+	BLOCKS_MAX_LIMIT                   = 100
+
+	MAX_TRANSACTION_BODY_BYTES = 64 << 10 // PUT/POST /transactions のbodyの上限
+	MAX_BLOCK_BODY_BYTES       = 8 << 20  // POST /blocks のbodyの上限
+	MAX_HEADER_BYTES           = 64 << 10
+	READ_HEADER_TIMEOUT        = 10 * time.Second
 )
 
 var cache map[string]*block.Blockchain = make(map[string]*block.Blockchain)
+var cacheMux sync.Mutex
 
 type BlockchainServer struct {
-	port uint16
+	port    uint16
+	dataDir string   // ブロックを保存するディレクトリ（空の場合はメモリ上のみ）
+	peers   []string // 隣のノード("host:port")。空の場合はポートの範囲を走査して探す
+
+	miningInterval  time.Duration
+	miningThreshold int
+	autoMining      bool   // 起動時に自動マイニングを開始するか
+	minerAddress    string // マイニングの報酬を受け取るアドレス（空の場合はdataDirに保存した鍵のアドレス）
+	miner           *Miner
+	chainIndex      *ChainIndex
+}
+
+func NewBlockchainServer(port uint16, dataDir string, peers []string) *BlockchainServer {
+	return &BlockchainServer{port: port, dataDir: dataDir, peers: peers, chainIndex: NewChainIndex()}
 }
 
-func NewBlockchainServer(port uint16) *BlockchainServer {
-	return &BlockchainServer{port}
+// SetMining 自動マイニングの間隔と、マイニングするtransactionPoolの件数
+func (bcs *BlockchainServer) SetMining(interval time.Duration, threshold int, autoStart bool) {
+	bcs.miningInterval = interval
+	bcs.miningThreshold = threshold
+	bcs.autoMining = autoStart
+}
+
+// SetMinerAddress マイニングの報酬を受け取るアドレス（鍵はこのノードの外で管理する）
+func (bcs *BlockchainServer) SetMinerAddress(blockchainAddress string) {
+	bcs.minerAddress = blockchainAddress
 }
 
 func (bcs *BlockchainServer) Port() uint16 {
 	return bcs.port
 }
 
+func (bcs *BlockchainServer) DataDir() string {
+	return bcs.dataDir
+}
+
 func (bcs *BlockchainServer) GetBlockchain() *block.Blockchain {
+	cacheMux.Lock()
+	defer cacheMux.Unlock()
 	bc, ok := cache["blockchain"]
 	if !ok {
-		minersWallet := wallet.NewWallet()
-		bc = block.NewBlockchain(minersWallet.BlockchainAddress(), bcs.Port())
+		var storage block.Storage
+		if bcs.DataDir() != "" {
+			fs, err := block.NewFileStorage(bcs.DataDir())
+			if err != nil {
+				log.Fatalf("ERROR: %v", err)
+			}
+			storage = fs
+		}
+		minerAddress, err := bcs.minerBlockchainAddress()
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		bc, err = block.NewBlockchain(minerAddress, bcs.Port(), storage)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		bc.SetStaticNeighbors(bcs.peers)
 		cache["blockchain"] = bc
-		log.Printf("private_key %v", minersWallet.PrivateKeyStr())
-		log.Printf("publick_key %v", minersWallet.PublicKeyStr())
-		log.Printf("blockchain_address %v", minersWallet.BlockchainAddress())
+		log.Printf("miner_blockchain_address %v", minerAddress)
 	}
 	return bc
 }
 
+// minerBlockchainAddress
+// -miner-addressがあればそのアドレス、なければdataDirに保存した鍵のアドレス
+// dataDirもない場合は、再起動すると鍵がなくなる（報酬を使えなくなる）ので警告する
+func (bcs *BlockchainServer) minerBlockchainAddress() (string, error) {
+	if bcs.minerAddress != "" {
+		return bcs.minerAddress, nil
+	}
+	if bcs.DataDir() == "" {
+		log.Println("WARNING: mining rewards go to a temporary key that is lost on exit; set -miner-address or -datadir")
+		return wallet.NewWallet().BlockchainAddress(), nil
+	}
+	w, err := loadMinerWallet(bcs.DataDir())
+	if err != nil {
+		return "", err
+	}
+	return w.BlockchainAddress(), nil
+}
+
 func (bcs *BlockchainServer) GetChain(w http.ResponseWriter, req *http.Request) {
+	// "/" は他のルートに一致しないパスもすべて受け取る
+	if req.URL.Path != "/" {
+		notFound(w, req)
+		return
+	}
 	switch req.Method {
 	case http.MethodGet:
-		w.Header().Add("Content-Type", "application/json")
 		bc := bcs.GetBlockchain()
 		m, _ := bc.MarshalJSON()
-		io.WriteString(w, string(m[:]))
+		utils.WriteJson(w, http.StatusOK, m)
 	default:
-		log.Printf("ERROR: Invalid HTTP Method")
+		utils.MethodNotAllowed(w, req, http.MethodGet)
 	}
 }
 
 func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
-		w.Header().Add("Content-Type", "application/json")
 		bc := bcs.GetBlockchain()
 		transactions := bc.TransactionPool()
 		m, _ := json.Marshal(struct {
@@ -63,48 +143,526 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 			Transactions: transactions,
 			Length:       len(transactions),
 		})
-		io.WriteString(w, string(m[:]))
+		utils.WriteJson(w, http.StatusOK, m)
 
-	case http.MethodPost:
-		decoder := json.NewDecoder(req.Body)
+	case http.MethodPost, http.MethodPut:
 		var t block.TransactionRequest
-		err := decoder.Decode(&t)
-		if err != nil {
-			log.Printf("ERROR: %v", err)
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+		if err := utils.DecodeJsonBody(w, req, &t, MAX_TRANSACTION_BODY_BYTES); err != nil {
+			utils.WriteHTTPError(w, err)
 			return
 		}
 		if !t.Validate() {
-			log.Println("ERROR: missing field(s)")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			utils.WriteError(w, http.StatusUnprocessableEntity, utils.ERROR_INVALID_FIELD, "missing field(s)")
+			return
+		}
+		// 公開鍵・署名の形式が読み込めない場合は400、読み込めてもルールで受け付けられない場合は422
+		publicKey, err := utils.PublicKeyFromString(*t.SenderPublicKey)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, utils.ERROR_INVALID_PARAMETER, err.Error())
+			return
+		}
+		signature, err := utils.SignatureFromString(*t.Signature)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, utils.ERROR_INVALID_PARAMETER, err.Error())
+			return
+		}
+		// POST（walletから）もPUT（隣のノードから）も、新しいトランザクションであれば隣のノードに送る
+		bc := bcs.GetBlockchain()
+		transaction, err := bc.CreateTransaction(*t.SenderBlockchainAddress,
+			*t.RecipientBlockchainAddress, *t.Value, *t.Nonce, publicKey, signature)
+		status := http.StatusCreated
+		switch {
+		case errors.Is(err, block.ErrKnownTransaction):
+			status = http.StatusOK
+		case err != nil:
+			// 署名・nonce・残高などのルールで受け付けられない
+			utils.WriteError(w, http.StatusUnprocessableEntity, utils.ERROR_REJECTED, err.Error())
+			return
+		}
+		m, _ := json.Marshal(struct {
+			Message string `json:"message"`
+			ID      string `json:"id"`
+		}{
+			Message: "success",
+			ID:      transaction.ID(),
+		})
+		utils.WriteJson(w, status, m)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet, http.MethodPost, http.MethodPut)
+	}
+}
+
+// Nonce 指定したアドレスが次の送金で使うnonceを返す
+func (bcs *BlockchainServer) Nonce(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		if blockchainAddress == "" {
+			utils.WriteError(w, http.StatusBadRequest, utils.ERROR_INVALID_PARAMETER, "missing blockchain_address")
+			return
+		}
+		if err := utils.ValidateAddress(blockchainAddress); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, utils.ERROR_INVALID_PARAMETER, err.Error())
 			return
 		}
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
 		bc := bcs.GetBlockchain()
-		isCreated := bc.CreateTransaction(*t.SenderBlockchainAddress,
-			*t.RecipientBlockchainAddress, *t.Value, publicKey, signature)
+		m, _ := json.Marshal(struct {
+			Nonce uint64 `json:"nonce"`
+		}{
+			Nonce: bc.NextNonce(blockchainAddress),
+		})
+		utils.WriteJson(w, http.StatusOK, m)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+// Blocks
+// GET /blocks?from=&limit= 高さfromからのブロックを返す
+// POST /blocks 隣のノードがマイニングしたブロックを受け取る
+// （201: 繋げた、200: すでにある、409: 最後のブロックに繋がらない、422: ルールに合わない）
+func (bcs *BlockchainServer) Blocks(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		from, limit, err := parsePagination(req, "from", BLOCKS_DEFAULT_LIMIT, BLOCKS_MAX_LIMIT)
+		if err != nil {
+			utils.WriteHTTPError(w, err)
+			return
+		}
+		bcs.chainIndex.Sync(bcs.GetBlockchain().Chain())
+		blocks, length := bcs.chainIndex.Blocks(from, limit)
+		m, _ := json.Marshal(struct {
+			Blocks []block.ChainBlock `json:"blocks"`
+			From   int                `json:"from"`
+			Limit  int                `json:"limit"`
+			Length int                `json:"length"`
+		}{
+			Blocks: blocks,
+			From:   from,
+			Limit:  limit,
+			Length: length,
+		})
+		utils.WriteJson(w, http.StatusOK, m)
+	case http.MethodPost:
+		var b block.Block
+		if err := utils.DecodeJsonBody(w, req, &b, MAX_BLOCK_BODY_BYTES); err != nil {
+			utils.WriteHTTPError(w, err)
+			return
+		}
+		bc := bcs.GetBlockchain()
+		err := bc.ReceiveBlock(&b)
+		switch {
+		case err == nil:
+			utils.WriteJson(w, http.StatusCreated, utils.JsonStatus("success"))
+		case errors.Is(err, block.ErrKnownBlock):
+			// 隣のノードから同じブロックが戻ってきた
+			utils.WriteJson(w, http.StatusOK, utils.JsonStatus("success"))
+		case errors.Is(err, block.ErrPreviousHash):
+			// 最後のブロックに繋がらない（どちらかのchainが進んでいる）
+			utils.WriteError(w, http.StatusConflict, utils.ERROR_CONFLICT, err.Error())
+		case errors.As(err, new(*block.ChainError)), errors.Is(err, block.ErrNilBlock):
+			// ブロック自体がルールに合わない
+			utils.WriteError(w, http.StatusUnprocessableEntity, utils.ERROR_REJECTED, err.Error())
+		default:
+			// 保存に失敗した
+			utils.WriteHTTPError(w, err)
+		}
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet, http.MethodPost)
+	}
+}
+
+// Block
+// GET /blocks/{height} 高さのブロックを返す
+// GET /blocks/hash/{hash} ハッシュが一致するブロックを返す
+// GET /blocks/latest 最新のブロックを返す
+func (bcs *BlockchainServer) Block(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bcs.chainIndex.Sync(bcs.GetBlockchain().Chain())
+		var b block.ChainBlock
+		var found bool
+		parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/blocks/"), "/")
+		switch {
+		case len(parts) == 1 && parts[0] == "latest":
+			b, found = bcs.chainIndex.Latest()
+		case len(parts) == 1:
+			height, err := strconv.Atoi(parts[0])
+			if err != nil || height < 0 {
+				utils.WriteError(w, http.StatusBadRequest, utils.ERROR_INVALID_PARAMETER, fmt.Sprintf("invalid block height %q", parts[0]))
+				return
+			}
+			b, found = bcs.chainIndex.Block(height)
+		case len(parts) == 2 && parts[0] == "hash":
+			h, err := hex.DecodeString(parts[1])
+			if err != nil || len(h) != sha256.Size {
+				utils.WriteError(w, http.StatusBadRequest, utils.ERROR_INVALID_PARAMETER, fmt.Sprintf("invalid block hash %q", parts[1]))
+				return
+			}
+			var hash [sha256.Size]byte
+			copy(hash[:], h)
+			b, found = bcs.chainIndex.BlockByHash(hash)
+		default:
+			notFound(w, req)
+			return
+		}
+
+		if !found {
+			utils.WriteError(w, http.StatusNotFound, utils.ERROR_NOT_FOUND, "block not found")
+			return
+		}
+		m, _ := json.Marshal(b)
+		utils.WriteJson(w, http.StatusOK, m)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+// VerifyChain 自分のchainを先頭から検証した結果を返す
+func (bcs *BlockchainServer) VerifyChain(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bc := bcs.GetBlockchain()
+		chain := bc.Chain()
+		err := bc.ValidChain(chain)
+
+		result := struct {
+			Valid       bool   `json:"valid"`
+			Length      int    `json:"length"`
+			Height      *int   `json:"height,omitempty"`
+			Hash        string `json:"hash,omitempty"`
+			Transaction *int   `json:"transaction,omitempty"`
+			Reason      string `json:"reason,omitempty"`
+		}{
+			Valid:  err == nil,
+			Length: len(chain),
+		}
+		if ce, ok := err.(*block.ChainError); ok {
+			result.Height = &ce.Height
+			result.Hash = fmt.Sprintf("%x", ce.Hash)
+			if ce.Transaction >= 0 {
+				result.Transaction = &ce.Transaction
+			}
+			result.Reason = ce.Reason
+		}
+		m, _ := json.Marshal(result)
+		utils.WriteJson(w, http.StatusOK, m)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+// Tx
+// GET /tx/{id} トランザクションの状態（pending / confirmed）を返す
+// GET /tx/{id}/proof トランザクションのMerkle証明を返す
+func (bcs *BlockchainServer) Tx(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/tx/"), "/")
+		if len(parts) > 2 || (len(parts) == 2 && parts[1] != "proof") {
+			notFound(w, req)
+			return
+		}
+		txHash, err := block.ParseTransactionID(parts[0])
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, utils.ERROR_INVALID_PARAMETER, err.Error())
+			return
+		}
 
-		w.Header().Add("Content-Type", "application/json")
 		var m []byte
-		if !isCreated {
-			w.WriteHeader(http.StatusBadRequest)
-			m = utils.JsonStatus("fail")
+		if len(parts) == 2 {
+			m, err = bcs.transactionProof(txHash)
 		} else {
-			w.WriteHeader(http.StatusCreated)
-			m = utils.JsonStatus("success")
+			m, err = bcs.transactionStatus(txHash)
+		}
+		if err != nil {
+			utils.WriteError(w, http.StatusNotFound, utils.ERROR_NOT_FOUND, err.Error())
+			return
+		}
+		utils.WriteJson(w, http.StatusOK, m)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+func (bcs *BlockchainServer) transactionStatus(txHash [sha256.Size]byte) ([]byte, error) {
+	type txStatus struct {
+		Status        string             `json:"status"`
+		BlockHeight   *int               `json:"block_height,omitempty"`
+		BlockHash     string             `json:"block_hash,omitempty"`
+		Confirmations int                `json:"confirmations"`
+		Transaction   *block.Transaction `json:"transaction"`
+	}
+
+	bc := bcs.GetBlockchain()
+	bcs.chainIndex.Sync(bc.Chain())
+	if e, ok := bcs.chainIndex.Lookup(txHash); ok {
+		return json.Marshal(txStatus{
+			Status:        "confirmed",
+			BlockHeight:   &e.height,
+			BlockHash:     fmt.Sprintf("%x", e.block.Hash()),
+			Confirmations: e.confirmations,
+			Transaction:   e.transaction(),
+		})
+	}
+	for _, t := range bc.TransactionPool() {
+		if t.Hash() == txHash {
+			return json.Marshal(txStatus{
+				Status:      "pending",
+				Transaction: t,
+			})
+		}
+	}
+	return nil, block.ErrTransactionNotFound
+}
+
+func (bcs *BlockchainServer) transactionProof(txHash [sha256.Size]byte) ([]byte, error) {
+	bcs.chainIndex.Sync(bcs.GetBlockchain().Chain())
+	e, ok := bcs.chainIndex.Lookup(txHash)
+	if !ok {
+		return nil, block.ErrTransactionNotFound
+	}
+	proof, err := block.NewMerkleProof(e.block, e.height, e.index)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(proof)
+}
+
+// Address
+// GET /address/{addr}/balance 確定した残高と、transactionPoolを含めた残高を返す
+// GET /address/{addr}/transactions?offset=&limit= アドレスに関係するトランザクションを新しい順に返す
+func (bcs *BlockchainServer) Address(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/address/"), "/")
+		if len(parts) != 2 || parts[0] == "" {
+			notFound(w, req)
+			return
+		}
+		blockchainAddress := parts[0]
+		if err := utils.ValidateAddress(blockchainAddress); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, utils.ERROR_INVALID_PARAMETER, err.Error())
+			return
+		}
+
+		var m []byte
+		switch parts[1] {
+		case "balance":
+			m = bcs.addressBalance(blockchainAddress)
+		case "transactions":
+			offset, limit, err := parsePagination(req, "offset", ADDRESS_TRANSACTIONS_DEFAULT_LIMIT, ADDRESS_TRANSACTIONS_MAX_LIMIT)
+			if err != nil {
+				utils.WriteHTTPError(w, err)
+				return
+			}
+			m = bcs.addressTransactions(blockchainAddress, offset, limit)
+		default:
+			notFound(w, req)
+			return
 		}
-		io.WriteString(w, string(m))
+		utils.WriteJson(w, http.StatusOK, m)
 	default:
-		log.Println("ERROR: Invalid HTTP Method")
-		w.WriteHeader(http.StatusBadRequest)
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+func (bcs *BlockchainServer) addressBalance(blockchainAddress string) []byte {
+	bc := bcs.GetBlockchain()
+	bcs.chainIndex.Sync(bc.Chain())
+	confirmed := bcs.chainIndex.Balance(blockchainAddress)
+	pending := confirmed
+	for _, t := range bc.TransactionPool() {
+		if t.SenderBlockchainAddress() == blockchainAddress {
+			pending -= t.Value()
+		}
+		if t.RecipientBlockchainAddress() == blockchainAddress {
+			pending += t.Value()
+		}
+	}
+	m, _ := json.Marshal(struct {
+		BlockchainAddress string       `json:"blockchain_address"`
+		Confirmed         utils.Amount `json:"confirmed"`
+		Pending           utils.Amount `json:"pending"`
+	}{
+		BlockchainAddress: blockchainAddress,
+		Confirmed:         confirmed,
+		Pending:           pending,
+	})
+	return m
+}
+
+func (bcs *BlockchainServer) addressTransactions(blockchainAddress string, offset int, limit int) []byte {
+	type historyItem struct {
+		BlockHeight   int                `json:"block_height"`
+		BlockHash     string             `json:"block_hash"`
+		Confirmations int                `json:"confirmations"`
+		Transaction   *block.Transaction `json:"transaction"`
+	}
+
+	bcs.chainIndex.Sync(bcs.GetBlockchain().Chain())
+	entries, total := bcs.chainIndex.History(blockchainAddress, offset, limit)
+	items := make([]historyItem, len(entries))
+	for i, e := range entries {
+		items[i] = historyItem{
+			BlockHeight:   e.height,
+			BlockHash:     fmt.Sprintf("%x", e.block.Hash()),
+			Confirmations: e.confirmations,
+			Transaction:   e.transaction(),
+		}
 	}
+	m, _ := json.Marshal(struct {
+		BlockchainAddress string        `json:"blockchain_address"`
+		Total             int           `json:"total"`
+		Offset            int           `json:"offset"`
+		Limit             int           `json:"limit"`
+		Transactions      []historyItem `json:"transactions"`
+	}{
+		BlockchainAddress: blockchainAddress,
+		Total:             total,
+		Offset:            offset,
+		Limit:             limit,
+		Transactions:      items,
+	})
+	return m
+}
+
+// parsePagination
+// クエリの開始位置（offsetKeyの値）とlimitを読み込む（limitは1からmaxLimitまで）
+// 正しくない値の場合は400の*utils.HTTPError
+func parsePagination(req *http.Request, offsetKey string, defaultLimit int, maxLimit int) (int, int, error) {
+	offset, limit := 0, defaultLimit
+	q := req.URL.Query()
+	if v := q.Get(offsetKey); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, utils.NewHTTPError(http.StatusBadRequest, utils.ERROR_INVALID_PARAMETER,
+				fmt.Sprintf("invalid %s %q", offsetKey, v))
+		}
+		offset = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			return 0, 0, utils.NewHTTPError(http.StatusBadRequest, utils.ERROR_INVALID_PARAMETER,
+				fmt.Sprintf("invalid limit %q (1-%d)", v, maxLimit))
+		}
+		limit = n
+	}
+	return offset, limit, nil
+}
+
+// Mine 1ブロックだけマイニングする
+func (bcs *BlockchainServer) Mine(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		err := bcs.miner.MineNow()
+		if errors.Is(err, block.ErrChainChanged) {
+			// 隣のノードのブロックや同時に行ったマイニングが先に繋がった（やり直せば成功する）
+			utils.WriteError(w, http.StatusConflict, utils.ERROR_CONFLICT, err.Error())
+			return
+		}
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, utils.ERROR_INTERNAL, "mining failed")
+			return
+		}
+		utils.WriteJson(w, http.StatusOK, utils.JsonStatus("success"))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodPost)
+	}
+}
+
+// StartMine 自動マイニングを開始する
+func (bcs *BlockchainServer) StartMine(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		if !bcs.miner.Start() {
+			utils.WriteError(w, http.StatusConflict, utils.ERROR_CONFLICT, "mining is already running")
+			return
+		}
+		utils.WriteJson(w, http.StatusOK, utils.JsonStatus("success"))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodPost)
+	}
+}
+
+// StopMine 自動マイニングを止める
+func (bcs *BlockchainServer) StopMine(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		if !bcs.miner.Stop() {
+			utils.WriteError(w, http.StatusConflict, utils.ERROR_CONFLICT, "mining is not running")
+			return
+		}
+		utils.WriteJson(w, http.StatusOK, utils.JsonStatus("success"))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodPost)
+	}
+}
+
+// Consensus
+// 隣のノードのchainと比べて、計算量の合計が一番大きい正しいchainに揃える
+// 自分のchainの計算量が一番大きい場合は置き換えないので、replacedはfalseになる
+func (bcs *BlockchainServer) Consensus(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPut:
+		bc := bcs.GetBlockchain()
+		replaced := bc.ResolveConflicts()
+		m, _ := json.Marshal(struct {
+			Message  string `json:"message"`
+			Replaced bool   `json:"replaced"`
+		}{
+			Message:  "success",
+			Replaced: replaced,
+		})
+		utils.WriteJson(w, http.StatusOK, m)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodPut)
+	}
+}
+
+// notFound どのルートにも一致しないパス
+func notFound(w http.ResponseWriter, req *http.Request) {
+	utils.WriteError(w, http.StatusNotFound, utils.ERROR_NOT_FOUND, fmt.Sprintf("no route for %s", req.URL.Path))
+}
+
+// Handler すべてのルート
+func (bcs *BlockchainServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", bcs.GetChain)
+	mux.HandleFunc("/transactions", bcs.Transactions)
+	mux.HandleFunc("/nonce", bcs.Nonce)
+	mux.HandleFunc("/blocks", bcs.Blocks)
+	mux.HandleFunc("/blocks/", bcs.Block)
+	mux.HandleFunc("/consensus", bcs.Consensus)
+	mux.HandleFunc("/chain/verify", bcs.VerifyChain)
+	mux.HandleFunc("/tx/", bcs.Tx)
+	mux.HandleFunc("/address/", bcs.Address)
+	mux.HandleFunc("/mine", bcs.Mine)
+	mux.HandleFunc("/mine/start", bcs.StartMine)
+	mux.HandleFunc("/mine/stop", bcs.StopMine)
+	return mux
 }
 
 // RUN
 // cf. https://go.dev/doc/articles/wiki/
 func (bcs *BlockchainServer) Run() {
-	http.HandleFunc("/", bcs.GetChain)
-	http.HandleFunc("/transactions", bcs.Transactions)
-	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(bcs.Port())), nil))
+	bc := bcs.GetBlockchain()
+	bc.StartSyncNeighbors()
+	bc.ResolveConflicts()
+
+	bcs.chainIndex.Sync(bc.Chain())
+
+	bcs.miner = NewMiner(bc, bcs.miningInterval, bcs.miningThreshold)
+	if bcs.autoMining {
+		bcs.miner.Start()
+	}
+
+	server := &http.Server{
+		Addr:              "0.0.0.0:" + strconv.Itoa(int(bcs.Port())),
+		Handler:           bcs.Handler(),
+		MaxHeaderBytes:    MAX_HEADER_BYTES,
+		ReadHeaderTimeout: READ_HEADER_TIMEOUT,
+	}
+	log.Fatal(server.ListenAndServe())
 }
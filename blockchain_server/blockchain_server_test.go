@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go_blockchain/block"
+	"go_blockchain/utils"
+	"go_blockchain/wallet"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestServer マイニングの報酬をwに送るノード（隣のノードなし、メモリ上のみ）
+func newTestServer(t *testing.T, w *wallet.Wallet) (*BlockchainServer, *block.Blockchain, *httptest.Server) {
+	t.Helper()
+	bc, err := block.NewBlockchain(w.BlockchainAddress(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheMux.Lock()
+	cache["blockchain"] = bc
+	cacheMux.Unlock()
+
+	bcs := NewBlockchainServer(0, "", nil)
+	bcs.miner = NewMiner(bc, 0, 0)
+	ts := httptest.NewServer(bcs.Handler())
+	t.Cleanup(ts.Close)
+	return bcs, bc, ts
+}
+
+func do(t *testing.T, method string, url string, body interface{}) (int, []byte) {
+	t.Helper()
+	var r *bytes.Reader
+	if body != nil {
+		m, _ := json.Marshal(body)
+		r = bytes.NewReader(m)
+	} else {
+		r = bytes.NewReader(nil)
+	}
+	req, _ := http.NewRequest(method, url, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s %s: %v", method, url, err)
+		return 0, nil
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	return resp.StatusCode, buf.Bytes()
+}
+
+// sendTransaction nonceを取得して署名し、POST /transactionsする
+func sendTransaction(t *testing.T, url string, w *wallet.Wallet, recipient string, value utils.Amount) int {
+	status, m := do(t, http.MethodGet, url+"/nonce?blockchain_address="+w.BlockchainAddress(), nil)
+	if status != http.StatusOK {
+		t.Errorf("GET /nonce: status=%d %s", status, m)
+		return status
+	}
+	var n struct {
+		Nonce uint64 `json:"nonce"`
+	}
+	json.Unmarshal(m, &n)
+
+	sender := w.BlockchainAddress()
+	signature := wallet.NewTransaction(w.PrivateKey(), w.PublicKey(), sender, recipient, value, n.Nonce).GenerateSignature().String()
+	publicKey := w.PublicKeyStr()
+	status, _ = do(t, http.MethodPost, url+"/transactions", &block.TransactionRequest{
+		SenderBlockchainAddress:    &sender,
+		RecipientBlockchainAddress: &recipient,
+		SenderPublicKey:            &publicKey,
+		Value:                      &value,
+		Nonce:                      &n.Nonce,
+		Signature:                  &signature,
+	})
+	return status
+}
+
+// TestConcurrentHandlers
+// トランザクションの送信・chainとtransactionPoolの取得・マイニングを同時に行っても、
+// データ競合がなく（go test -race）、chainが正しいままであることを確かめる
+func TestConcurrentHandlers(t *testing.T) {
+	miner := wallet.NewWallet()
+	_, bc, ts := newTestServer(t, miner)
+	for i := 0; i < 3; i++ {
+		if status, m := do(t, http.MethodPost, ts.URL+"/mine", nil); status != http.StatusOK {
+			t.Fatalf("POST /mine: status=%d %s", status, m)
+		}
+	}
+
+	value, _ := utils.ParseAmount("0.01")
+	var wg sync.WaitGroup
+	var accepted int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recipient := wallet.NewWallet().BlockchainAddress()
+			for j := 0; j < 5; j++ {
+				// 同じ送信者のnonceを同時に使うので、後から届いた方は422になる
+				status := sendTransaction(t, ts.URL, miner, recipient, value)
+				if status == http.StatusCreated {
+					atomic.AddInt32(&accepted, 1)
+				} else if status != http.StatusUnprocessableEntity {
+					t.Errorf("POST /transactions: status=%d", status)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if status, m := do(t, http.MethodGet, ts.URL+"/", nil); status != http.StatusOK {
+					t.Errorf("GET /: status=%d %s", status, m)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if status, m := do(t, http.MethodGet, ts.URL+"/transactions", nil); status != http.StatusOK {
+					t.Errorf("GET /transactions: status=%d %s", status, m)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				// 他のマイニングが先に終わった場合はchainが変わったので409になる
+				status, m := do(t, http.MethodPost, ts.URL+"/mine", nil)
+				if status != http.StatusOK && status != http.StatusConflict {
+					t.Errorf("POST /mine: status=%d %s", status, m)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted == 0 {
+		t.Fatal("no transaction was accepted")
+	}
+
+	if err := bc.ValidChain(bc.Chain()); err != nil {
+		t.Fatalf("ValidChain: %v", err)
+	}
+	status, m := do(t, http.MethodGet, ts.URL+"/chain/verify", nil)
+	var v struct {
+		Valid bool `json:"valid"`
+	}
+	if err := json.Unmarshal(m, &v); err != nil || status != http.StatusOK || !v.Valid {
+		t.Fatalf("GET /chain/verify: status=%d %s", status, m)
+	}
+	// 残高は負にならない
+	if balance := bc.CalculateTotalAmount(miner.BlockchainAddress()); balance < 0 {
+		t.Fatalf("negative balance %s", balance)
+	}
+}
+
+// TestPostBlocksStatus
+// POST /blocks は、すでにあるブロックに200、最後のブロックに繋がらないブロックに409、
+// ルールに合わないブロックに422、Content-Typeのないリクエストに415を返す
+func TestPostBlocksStatus(t *testing.T) {
+	_, bc, ts := newTestServer(t, wallet.NewWallet())
+	if status, m := do(t, http.MethodPost, ts.URL+"/mine", nil); status != http.StatusOK {
+		t.Fatalf("POST /mine: status=%d %s", status, m)
+	}
+	last := bc.LastBlock()
+	blockWith := func(previousHash string) map[string]interface{} {
+		m, _ := json.Marshal(last)
+		// timestampがfloat64で丸められないようにする
+		decoder := json.NewDecoder(bytes.NewReader(m))
+		decoder.UseNumber()
+		var b map[string]interface{}
+		decoder.Decode(&b)
+		if previousHash != "" {
+			b["previous_hash"] = previousHash
+		}
+		return b
+	}
+	lastHash := last.Hash()
+
+	for _, c := range []struct {
+		name   string
+		body   map[string]interface{}
+		status int
+	}{
+		{"known", blockWith(""), http.StatusOK},
+		{"unknown previous_hash", blockWith(fmt.Sprintf("%064x", 1)), http.StatusConflict},
+		// 最後のブロックには繋がるが、タイムスタンプやnonceが正しくない
+		{"invalid", blockWith(fmt.Sprintf("%x", lastHash)), http.StatusUnprocessableEntity},
+	} {
+		if status, m := do(t, http.MethodPost, ts.URL+"/blocks", c.body); status != c.status {
+			t.Errorf("%s: status=%d, want %d %s", c.name, status, c.status, m)
+		}
+	}
+
+	m, _ := json.Marshal(blockWith(""))
+	resp, err := http.Post(ts.URL+"/blocks", "", bytes.NewReader(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("without Content-Type: status=%d, want %d", resp.StatusCode, http.StatusUnsupportedMediaType)
+	}
+}
+
+// TestPostTransactionsStatus
+// 公開鍵・署名の形式が読み込めない場合は400、読み込めても署名が正しくない場合は422
+func TestPostTransactionsStatus(t *testing.T) {
+	w := wallet.NewWallet()
+	_, _, ts := newTestServer(t, w)
+	sender := w.BlockchainAddress()
+	recipient := wallet.NewWallet().BlockchainAddress()
+	value, _ := utils.ParseAmount("1")
+	var nonce uint64
+	publicKey := w.PublicKeyStr()
+	signature := wallet.NewTransaction(w.PrivateKey(), w.PublicKey(), sender, recipient, value, nonce).GenerateSignature().String()
+	otherKey := wallet.NewWallet().PublicKeyStr()
+
+	for _, c := range []struct {
+		name      string
+		publicKey string
+		signature string
+		status    int
+	}{
+		{"malformed public key", "zz", signature, http.StatusBadRequest},
+		{"malformed signature", publicKey, "zz", http.StatusBadRequest},
+		{"wrong signature", otherKey, signature, http.StatusUnprocessableEntity},
+	} {
+		status, m := do(t, http.MethodPost, ts.URL+"/transactions", &block.TransactionRequest{
+			SenderBlockchainAddress:    &sender,
+			RecipientBlockchainAddress: &recipient,
+			SenderPublicKey:            &c.publicKey,
+			Value:                      &value,
+			Nonce:                      &nonce,
+			Signature:                  &c.signature,
+		})
+		if status != c.status {
+			t.Errorf("%s: status=%d, want %d %s", c.name, status, c.status, m)
+		}
+	}
+}
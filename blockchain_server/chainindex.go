@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/sha256"
+	"go_blockchain/block"
+	"go_blockchain/utils"
+	"sync"
+)
+
+// txLocation トランザクションが入っているブロックの高さと、ブロック内の位置
+type txLocation struct {
+	height int
+	index  int
+}
+
+// txEntry indexから引いたトランザクションと、それが入っているブロック
+type txEntry struct {
+	block         *block.Block
+	height        int
+	index         int
+	confirmations int // 入っているブロックを含めた、それ以降のブロックの数
+}
+
+func (e txEntry) transaction() *block.Transaction {
+	return e.block.Transactions()[e.index]
+}
+
+// ChainIndex
+// ブロックのハッシュ、トランザクションのID、アドレスから、chainのどこに入っているかを引けるようにする
+// chainが伸びた分だけ追加し、入れ替わった場合は分岐した地点から作り直す
+type ChainIndex struct {
+	mux       sync.Mutex
+	blocks    []indexedBlock // 高さごとのブロック（chainの入れ替わりを検出する）
+	heights   map[[sha256.Size]byte]int
+	locations map[[sha256.Size]byte]txLocation
+	addresses map[string]*addressEntry
+}
+
+type indexedBlock struct {
+	block    *block.Block
+	hash     [sha256.Size]byte
+	txHashes [][sha256.Size]byte
+}
+
+// addressEntry アドレスごとの確定した残高と、関係するトランザクション（古い順）
+type addressEntry struct {
+	balance utils.Amount
+	history []txLocation
+}
+
+func NewChainIndex() *ChainIndex {
+	return &ChainIndex{
+		heights:   make(map[[sha256.Size]byte]int),
+		locations: make(map[[sha256.Size]byte]txLocation),
+		addresses: make(map[string]*addressEntry),
+	}
+}
+
+// Sync indexをchainに合わせる
+func (idx *ChainIndex) Sync(chain []*block.Block) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	// 後ろから見て、同じブロックが見つかった所より前は作り直さない
+	// (ハッシュで繋がっているので、それより前のブロックも同じ)
+	fork := len(idx.blocks)
+	if len(chain) < fork {
+		fork = len(chain)
+	}
+	for fork > 0 && idx.blocks[fork-1].hash != chain[fork-1].Hash() {
+		fork--
+	}
+
+	// 追加した時と逆の順番で取り除く
+	for height := len(idx.blocks) - 1; height >= fork; height-- {
+		ib := idx.blocks[height]
+		txs := ib.block.Transactions()
+		for i := len(txs) - 1; i >= 0; i-- {
+			delete(idx.locations, ib.txHashes[i])
+			idx.unindexAddresses(txs[i])
+		}
+		delete(idx.heights, ib.hash)
+	}
+	idx.blocks = idx.blocks[:fork]
+
+	for height := fork; height < len(chain); height++ {
+		b := chain[height]
+		ib := indexedBlock{block: b, hash: b.Hash()}
+		for i, t := range b.Transactions() {
+			h := t.Hash()
+			loc := txLocation{height: height, index: i}
+			idx.locations[h] = loc
+			idx.indexAddresses(t, loc)
+			ib.txHashes = append(ib.txHashes, h)
+		}
+		idx.heights[ib.hash] = height
+		idx.blocks = append(idx.blocks, ib)
+	}
+}
+
+func (idx *ChainIndex) indexAddresses(t *block.Transaction, loc txLocation) {
+	if sender := t.SenderBlockchainAddress(); sender != block.MINING_SENDER {
+		e := idx.address(sender)
+		e.balance -= t.Value()
+		e.history = append(e.history, loc)
+	}
+	e := idx.address(t.RecipientBlockchainAddress())
+	e.balance += t.Value()
+	// 自分宛ての送金は履歴に1回だけ載せる
+	if n := len(e.history); n == 0 || e.history[n-1] != loc {
+		e.history = append(e.history, loc)
+	}
+}
+
+func (idx *ChainIndex) unindexAddresses(t *block.Transaction) {
+	sender, recipient := t.SenderBlockchainAddress(), t.RecipientBlockchainAddress()
+	if sender != block.MINING_SENDER {
+		idx.addresses[sender].balance += t.Value()
+	}
+	idx.addresses[recipient].balance -= t.Value()
+	idx.popHistory(recipient)
+	if sender != block.MINING_SENDER && sender != recipient {
+		idx.popHistory(sender)
+	}
+}
+
+// popHistory 最後に追加したトランザクションをアドレスの履歴から取り除く
+func (idx *ChainIndex) popHistory(blockchainAddress string) {
+	e := idx.addresses[blockchainAddress]
+	e.history = e.history[:len(e.history)-1]
+	if len(e.history) == 0 {
+		delete(idx.addresses, blockchainAddress)
+	}
+}
+
+func (idx *ChainIndex) address(blockchainAddress string) *addressEntry {
+	e, ok := idx.addresses[blockchainAddress]
+	if !ok {
+		e = &addressEntry{}
+		idx.addresses[blockchainAddress] = e
+	}
+	return e
+}
+
+func (idx *ChainIndex) entry(loc txLocation) txEntry {
+	return txEntry{
+		block:         idx.blocks[loc.height].block,
+		height:        loc.height,
+		index:         loc.index,
+		confirmations: len(idx.blocks) - loc.height,
+	}
+}
+
+// Lookup IDのトランザクションと、それが入っているブロック
+func (idx *ChainIndex) Lookup(txHash [sha256.Size]byte) (txEntry, bool) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+	loc, ok := idx.locations[txHash]
+	if !ok {
+		return txEntry{}, false
+	}
+	return idx.entry(loc), true
+}
+
+// Balance アドレスの確定した残高
+func (idx *ChainIndex) Balance(blockchainAddress string) utils.Amount {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+	if e, ok := idx.addresses[blockchainAddress]; ok {
+		return e.balance
+	}
+	return 0
+}
+
+// History
+// アドレスに関係するトランザクションを新しい順にoffset件飛ばしてlimit件まで返す
+// 2つ目の戻り値は全体の件数
+func (idx *ChainIndex) History(blockchainAddress string, offset int, limit int) ([]txEntry, int) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+	e, ok := idx.addresses[blockchainAddress]
+	if !ok {
+		return []txEntry{}, 0
+	}
+	total := len(e.history)
+	entries := make([]txEntry, 0, limit)
+	for i := total - 1 - offset; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, idx.entry(e.history[i]))
+	}
+	return entries, total
+}
+
+// Block heightのブロック
+func (idx *ChainIndex) Block(height int) (block.ChainBlock, bool) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+	if height < 0 || height >= len(idx.blocks) {
+		return block.ChainBlock{}, false
+	}
+	return block.ChainBlock{Height: height, Block: idx.blocks[height].block}, true
+}
+
+// BlockByHash ハッシュが一致するブロック
+func (idx *ChainIndex) BlockByHash(hash [sha256.Size]byte) (block.ChainBlock, bool) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+	height, ok := idx.heights[hash]
+	if !ok {
+		return block.ChainBlock{}, false
+	}
+	return block.ChainBlock{Height: height, Block: idx.blocks[height].block}, true
+}
+
+// Blocks
+// 高さfromからlimit件までのブロックを古い順に返す
+// 2つ目の戻り値はchainの長さ
+func (idx *ChainIndex) Blocks(from int, limit int) ([]block.ChainBlock, int) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+	blocks := make([]block.ChainBlock, 0, limit)
+	for height := from; height < len(idx.blocks) && len(blocks) < limit; height++ {
+		blocks = append(blocks, block.ChainBlock{Height: height, Block: idx.blocks[height].block})
+	}
+	return blocks, len(idx.blocks)
+}
+
+// Latest 最新のブロック
+func (idx *ChainIndex) Latest() (block.ChainBlock, bool) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+	if len(idx.blocks) == 0 {
+		return block.ChainBlock{}, false
+	}
+	height := len(idx.blocks) - 1
+	return block.ChainBlock{Height: height, Block: idx.blocks[height].block}, true
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"flag"
+	"go_blockchain/utils"
 	"log"
+	"strings"
+	"time"
 )
 
 func init() {
@@ -11,7 +14,32 @@ func init() {
 
 func main() {
 	port := flag.Uint("port", 5001, "TCP Port Number for Blockchain Server")
+	dataDir := flag.String("datadir", "", "Directory to store blocks (in-memory if empty)")
+	peers := flag.String("peers", "", "Comma-separated neighbor nodes host:port (scan local ports if empty)")
+	mine := flag.Bool("mine", false, "Start mining automatically")
+	miningInterval := flag.Duration("mining-interval", 20*time.Second, "Mine a block at this interval (0 to disable)")
+	miningThreshold := flag.Int("mining-threshold", 0, "Mine a block when the transaction pool reaches this size (0 to disable)")
+	minerAddress := flag.String("miner-address", "", "Blockchain address for mining rewards (a key kept in -datadir if empty)")
 	flag.Parse()
-	app := NewBlockchainServer(uint16(*port))
+
+	if *minerAddress != "" {
+		if err := utils.ValidateAddress(*minerAddress); err != nil {
+			log.Fatalf("ERROR: invalid miner address %q: %v", *minerAddress, err)
+		}
+	}
+
+	var neighbors []string
+	for _, p := range strings.Split(*peers, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, _, err := utils.ParseNeighbor(p); err != nil {
+			log.Fatalf("ERROR: invalid peer %q: %v", p, err)
+		}
+		neighbors = append(neighbors, p)
+	}
+	app := NewBlockchainServer(uint16(*port), *dataDir, neighbors)
+	app.SetMining(*miningInterval, *miningThreshold, *mine)
+	app.SetMinerAddress(*minerAddress)
 	app.Run()
 }
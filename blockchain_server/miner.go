@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_blockchain/block"
+	"go_blockchain/utils"
+	"go_blockchain/wallet"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	MINING_POLL_INTERVAL = 1 * time.Second  // transactionPoolの件数を確認する間隔
+	MINER_KEY_FILE_NAME  = "miner_key.json" // -datadirに保存する、マイニングの報酬を受け取る鍵
+)
+
+// Miner
+// 一定間隔ごと、またはtransactionPoolが閾値に達した時にマイニングする
+type Miner struct {
+	bc        *block.Blockchain
+	interval  time.Duration // 0の場合は間隔でのマイニングをしない
+	threshold int           // 0の場合は件数でのマイニングをしない
+
+	mux     sync.Mutex
+	running bool
+	stop    chan struct{}
+}
+
+func NewMiner(bc *block.Blockchain, interval time.Duration, threshold int) *Miner {
+	return &Miner{bc: bc, interval: interval, threshold: threshold}
+}
+
+func (m *Miner) Running() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.running
+}
+
+// Start 自動マイニングを開始する。すでに動いている場合はfalse
+func (m *Miner) Start() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.running {
+		return false
+	}
+	m.running = true
+	m.stop = make(chan struct{})
+	go m.loop(m.stop)
+	log.Printf("action=start_mining, interval=%v, threshold=%d", m.interval, m.threshold)
+	return true
+}
+
+// Stop 自動マイニングを止める。動いていない場合はfalse
+func (m *Miner) Stop() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if !m.running {
+		return false
+	}
+	close(m.stop)
+	m.running = false
+	log.Println("action=stop_mining")
+	return true
+}
+
+// MineNow 1ブロックだけマイニングする
+func (m *Miner) MineNow() error {
+	return m.bc.Mining()
+}
+
+func (m *Miner) loop(stop chan struct{}) {
+	poll := time.NewTicker(MINING_POLL_INTERVAL)
+	defer poll.Stop()
+	lastMined := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-poll.C:
+			dueByTime := m.interval > 0 && time.Since(lastMined) >= m.interval
+			dueByPool := m.threshold > 0 && len(m.bc.TransactionPool()) >= m.threshold
+			if dueByTime || dueByPool {
+				m.MineNow()
+				lastMined = time.Now()
+			}
+		}
+	}
+}
+
+// minerKeyFile MINER_KEY_FILE_NAMEの中身
+type minerKeyFile struct {
+	PublicKey  string `json:"public_key"`
+	PrivateKey string `json:"private_key"`
+}
+
+// loadMinerWallet
+// dataDirに保存したマイニングの報酬を受け取る鍵を読み込む。ない場合は作成して保存する
+// 再起動しても同じアドレスに報酬が入るようにする（ファイルは所有者だけが読める）
+func loadMinerWallet(dataDir string) (*wallet.Wallet, error) {
+	path := filepath.Join(dataDir, MINER_KEY_FILE_NAME)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createMinerWallet(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var k minerKeyFile
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	publicKey, err := utils.PublicKeyFromString(k.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	privateKey, err := utils.PrivateKeyFromString(k.PrivateKey, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return wallet.NewWalletFromPrivateKey(privateKey), nil
+}
+
+func createMinerWallet(path string) (*wallet.Wallet, error) {
+	w := wallet.NewWallet()
+	m, _ := json.Marshal(minerKeyFile{PublicKey: w.PublicKeyStr(), PrivateKey: w.PrivateKeyStr()})
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(m); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	log.Printf("action=create_miner_key, path=%s", path)
+	return w, nil
+}
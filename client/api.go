@@ -0,0 +1,269 @@
+package client
+
+import (
+	"context"
+	"go_blockchain/block"
+	"go_blockchain/utils"
+	"go_blockchain/wallet"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	TX_STATUS_CONFIRMED = "confirmed"
+	TX_STATUS_PENDING   = "pending"
+)
+
+// TransactionPool GET /transactions
+type TransactionPool struct {
+	Transactions []*block.Transaction `json:"transactions"`
+	Length       int                  `json:"length"`
+}
+
+// BlocksPage GET /blocks
+type BlocksPage struct {
+	Blocks []block.ChainBlock `json:"blocks"`
+	From   int                `json:"from"`
+	Limit  int                `json:"limit"`
+	Length int                `json:"length"` // chainの長さ
+}
+
+// ChainVerification GET /chain/verify
+type ChainVerification struct {
+	Valid       bool   `json:"valid"`
+	Length      int    `json:"length"`
+	Height      *int   `json:"height,omitempty"`
+	Hash        string `json:"hash,omitempty"`
+	Transaction *int   `json:"transaction,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// TransactionStatus GET /tx/{id}
+type TransactionStatus struct {
+	Status        string             `json:"status"` // TX_STATUS_CONFIRMED または TX_STATUS_PENDING
+	BlockHeight   *int               `json:"block_height,omitempty"`
+	BlockHash     string             `json:"block_hash,omitempty"`
+	Confirmations int                `json:"confirmations"`
+	Transaction   *block.Transaction `json:"transaction"`
+}
+
+// Balance GET /address/{addr}/balance
+type Balance struct {
+	BlockchainAddress string       `json:"blockchain_address"`
+	Confirmed         utils.Amount `json:"confirmed"`
+	Pending           utils.Amount `json:"pending"` // transactionPoolを含めた残高
+}
+
+// AddressTransactions GET /address/{addr}/transactions
+type AddressTransactions struct {
+	BlockchainAddress string        `json:"blockchain_address"`
+	Total             int           `json:"total"`
+	Offset            int           `json:"offset"`
+	Limit             int           `json:"limit"`
+	Transactions      []HistoryItem `json:"transactions"`
+}
+
+type HistoryItem struct {
+	BlockHeight   int                `json:"block_height"`
+	BlockHash     string             `json:"block_hash"`
+	Confirmations int                `json:"confirmations"`
+	Transaction   *block.Transaction `json:"transaction"`
+}
+
+// Chain GET / chain全体
+func (c *Client) Chain(ctx context.Context) ([]block.ChainBlock, error) {
+	var v struct {
+		Chains []block.ChainBlock `json:"chains"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/", nil, &v); err != nil {
+		return nil, err
+	}
+	return v.Chains, nil
+}
+
+// TransactionPool GET /transactions まだブロックに入っていないトランザクション
+func (c *Client) TransactionPool(ctx context.Context) (*TransactionPool, error) {
+	var v TransactionPool
+	if err := c.do(ctx, http.MethodGet, "/transactions", nil, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// SubmitTransaction POST /transactions 署名済みのトランザクションを送り、トランザクションIDを返す
+func (c *Client) SubmitTransaction(ctx context.Context, tr *block.TransactionRequest) (string, error) {
+	return c.sendTransaction(ctx, http.MethodPost, tr)
+}
+
+// RelayTransaction PUT /transactions 隣のノードとして送る（受け取ったノードも新しいトランザクションであれば隣のノードに送る）
+func (c *Client) RelayTransaction(ctx context.Context, tr *block.TransactionRequest) (string, error) {
+	return c.sendTransaction(ctx, http.MethodPut, tr)
+}
+
+func (c *Client) sendTransaction(ctx context.Context, method string, tr *block.TransactionRequest) (string, error) {
+	var v struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, method, "/transactions", tr, &v); err != nil {
+		return "", err
+	}
+	return v.ID, nil
+}
+
+// Send
+// walletから送金する。nonceの取得・署名・送信をまとめて行い、トランザクションIDを返す
+func (c *Client) Send(ctx context.Context, w *wallet.Wallet, recipient string, value utils.Amount) (string, error) {
+	sender := w.BlockchainAddress()
+	nonce, err := c.Nonce(ctx, sender)
+	if err != nil {
+		return "", err
+	}
+	t := wallet.NewTransaction(w.PrivateKey(), w.PublicKey(), sender, recipient, value, nonce)
+	signature := t.GenerateSignature().String()
+	publicKey := w.PublicKeyStr()
+	return c.SubmitTransaction(ctx, &block.TransactionRequest{
+		SenderBlockchainAddress:    &sender,
+		RecipientBlockchainAddress: &recipient,
+		SenderPublicKey:            &publicKey,
+		Value:                      &value,
+		Nonce:                      &nonce,
+		Signature:                  &signature,
+	})
+}
+
+// Nonce GET /nonce アドレスが次の送金で使うnonce
+func (c *Client) Nonce(ctx context.Context, blockchainAddress string) (uint64, error) {
+	var v struct {
+		Nonce uint64 `json:"nonce"`
+	}
+	path := "/nonce?blockchain_address=" + url.QueryEscape(blockchainAddress)
+	if err := c.do(ctx, http.MethodGet, path, nil, &v); err != nil {
+		return 0, err
+	}
+	return v.Nonce, nil
+}
+
+// Blocks GET /blocks?from=&limit= 高さfromからlimit件のブロック（limitが0の場合はサーバーのデフォルト）
+func (c *Client) Blocks(ctx context.Context, from int, limit int) (*BlocksPage, error) {
+	var v BlocksPage
+	if err := c.do(ctx, http.MethodGet, "/blocks"+pageQuery("from", from, limit), nil, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// SubmitBlock POST /blocks 隣のノードとしてマイニングしたブロックを送る
+// 最後のブロックに繋がらない場合はcode conflict、ルールに合わない場合はcode rejectedのAPIError
+func (c *Client) SubmitBlock(ctx context.Context, b *block.Block) error {
+	return c.do(ctx, http.MethodPost, "/blocks", b, nil)
+}
+
+// Block GET /blocks/{height}
+func (c *Client) Block(ctx context.Context, height int) (*block.ChainBlock, error) {
+	return c.chainBlock(ctx, "/blocks/"+strconv.Itoa(height))
+}
+
+// BlockByHash GET /blocks/hash/{hash}（hashは16進数）
+func (c *Client) BlockByHash(ctx context.Context, hash string) (*block.ChainBlock, error) {
+	return c.chainBlock(ctx, "/blocks/hash/"+url.PathEscape(hash))
+}
+
+// LatestBlock GET /blocks/latest
+func (c *Client) LatestBlock(ctx context.Context) (*block.ChainBlock, error) {
+	return c.chainBlock(ctx, "/blocks/latest")
+}
+
+func (c *Client) chainBlock(ctx context.Context, path string) (*block.ChainBlock, error) {
+	var v block.ChainBlock
+	if err := c.do(ctx, http.MethodGet, path, nil, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// VerifyChain GET /chain/verify
+func (c *Client) VerifyChain(ctx context.Context) (*ChainVerification, error) {
+	var v ChainVerification
+	if err := c.do(ctx, http.MethodGet, "/chain/verify", nil, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Transaction GET /tx/{id}
+func (c *Client) Transaction(ctx context.Context, id string) (*TransactionStatus, error) {
+	var v TransactionStatus
+	if err := c.do(ctx, http.MethodGet, "/tx/"+url.PathEscape(id), nil, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// TransactionProof GET /tx/{id}/proof（Verifyで根とブロックのヘッダーのハッシュまで確認できる）
+func (c *Client) TransactionProof(ctx context.Context, id string) (*block.MerkleProof, error) {
+	var v block.MerkleProof
+	if err := c.do(ctx, http.MethodGet, "/tx/"+url.PathEscape(id)+"/proof", nil, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Balance GET /address/{addr}/balance
+func (c *Client) Balance(ctx context.Context, blockchainAddress string) (*Balance, error) {
+	var v Balance
+	if err := c.do(ctx, http.MethodGet, "/address/"+url.PathEscape(blockchainAddress)+"/balance", nil, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// AddressTransactions GET /address/{addr}/transactions?offset=&limit= 新しい順（limitが0の場合はサーバーのデフォルト）
+func (c *Client) AddressTransactions(ctx context.Context, blockchainAddress string, offset int, limit int) (*AddressTransactions, error) {
+	var v AddressTransactions
+	path := "/address/" + url.PathEscape(blockchainAddress) + "/transactions" + pageQuery("offset", offset, limit)
+	if err := c.do(ctx, http.MethodGet, path, nil, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Mine POST /mine 1ブロックだけマイニングする
+func (c *Client) Mine(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/mine", nil, nil)
+}
+
+// StartMining POST /mine/start
+func (c *Client) StartMining(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/mine/start", nil, nil)
+}
+
+// StopMining POST /mine/stop
+func (c *Client) StopMining(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/mine/stop", nil, nil)
+}
+
+// Consensus PUT /consensus 隣のノードのchainに置き換えた場合はtrue
+func (c *Client) Consensus(ctx context.Context) (bool, error) {
+	var v struct {
+		Replaced bool `json:"replaced"`
+	}
+	if err := c.do(ctx, http.MethodPut, "/consensus", nil, &v); err != nil {
+		return false, err
+	}
+	return v.Replaced, nil
+}
+
+func pageQuery(offsetKey string, offset int, limit int) string {
+	q := url.Values{}
+	if offset > 0 {
+		q.Set(offsetKey, strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_blockchain/utils"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// blockchain_serverのAPIのクライアント
+// cf. blockchain_server/blockchain_server.go
+
+const (
+	DEFAULT_TIMEOUT       = 5 * time.Second        // 1回のリクエストのタイムアウト
+	DEFAULT_RETRIES       = 3                      // 失敗した時にやり直す回数
+	DEFAULT_RETRY_BACKOFF = 200 * time.Millisecond // やり直すまでの待ち時間（やり直すたびに2倍にする）
+	maxResponseBytes      = 64 << 20
+)
+
+// APIError blockchain_serverがエラーのレスポンス（{"message":"fail","code":...,"reason":...}）を返した
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Code       utils.ErrorCode
+	Reason     string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: status=%d %s: %s", e.Method, e.Path, e.StatusCode, e.Code, e.Reason)
+}
+
+// TransportError blockchain_serverに繋がらない、またはタイムアウトした
+type TransportError struct {
+	Method string
+	Path   string
+	Err    error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Method, e.Path, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout タイムアウトした場合はtrue
+func (e *TransportError) Timeout() bool {
+	var ne net.Error
+	return errors.Is(e.Err, context.DeadlineExceeded) || (errors.As(e.Err, &ne) && ne.Timeout())
+}
+
+// IsNotFound 見つからなかった（404）エラーかどうか
+func IsNotFound(err error) bool {
+	var ae *APIError
+	return errors.As(err, &ae) && ae.StatusCode == http.StatusNotFound
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+}
+
+// NewClient baseURLはblockchain_serverのアドレス（例: "http://127.0.0.1:5001"）
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: DEFAULT_TIMEOUT},
+		retries:    DEFAULT_RETRIES,
+		backoff:    DEFAULT_RETRY_BACKOFF,
+	}
+}
+
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// SetTimeout 1回のリクエストのタイムアウト
+func (c *Client) SetTimeout(d time.Duration) {
+	c.httpClient.Timeout = d
+}
+
+// SetRetry 失敗した時にやり直す回数と、最初の待ち時間（0回の場合はやり直さない）
+func (c *Client) SetRetry(retries int, backoff time.Duration) {
+	c.retries = retries
+	c.backoff = backoff
+}
+
+// do
+// inをJSONにして送り、レスポンスのJSONをoutに読み込む
+// GETは繋がらない・5xxの場合にやり直す
+// それ以外のメソッドは届いたかどうか分からない場合にやり直すと二重に送ってしまうので、接続できなかった場合だけやり直す
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := c.try(ctx, method, path, body, out)
+		if err == nil || !retryable || attempt >= c.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return &TransportError{method, path, ctx.Err()}
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// try 1回だけリクエストする。やり直してよいエラーかどうかも返す
+func (c *Client) try(ctx context.Context, method string, path string, body []byte, out interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return method == http.MethodGet || isDialError(err), &TransportError{method, path, err}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return method == http.MethodGet, &TransportError{method, path, err}
+	}
+
+	if resp.StatusCode >= 300 {
+		e := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode}
+		v := struct {
+			Code   utils.ErrorCode `json:"code"`
+			Reason string          `json:"reason"`
+		}{}
+		if json.Unmarshal(data, &v) == nil {
+			e.Code, e.Reason = v.Code, v.Reason
+		}
+		if e.Reason == "" {
+			e.Reason = http.StatusText(resp.StatusCode)
+		}
+		return method == http.MethodGet && resp.StatusCode >= 500, e
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return false, fmt.Errorf("%s %s: invalid response: %w", method, path, err)
+		}
+	}
+	return false, nil
+}
+
+func isDialError(err error) bool {
+	var oe *net.OpError
+	return errors.As(err, &oe) && oe.Op == "dial"
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go_blockchain/utils"
+	"go_blockchain/wallet"
+	"io"
+	"os"
+	"strings"
+)
+
+// walletResult new, import の結果（秘密鍵は含めない）
+type walletResult struct {
+	BlockchainAddress string `json:"blockchain_address"`
+	PublicKey         string `json:"public_key"`
+	Curve             string `json:"curve"`
+	Mnemonic          string `json:"mnemonic,omitempty"` // -hd で作成した場合だけ。復元に必要なので控えておく
+	Path              string `json:"path,omitempty"`     // BIP44のpath
+}
+
+func newWalletResult(w *wallet.Wallet) *walletResult {
+	return &walletResult{
+		BlockchainAddress: w.BlockchainAddress(),
+		PublicKey:         w.PublicKeyStr(),
+		Curve:             w.PublicKey().Curve.Params().Name,
+	}
+}
+
+// newWallet new [-curve P-256|secp256k1] [-hd]
+// -hd の場合はBIP39のリカバリーフレーズを作り、BIP44の最初の受け取り用のwalletを保存する
+// BIP32の鍵はsecp256k1に決まっているので、-hd と -curve は一緒に指定できない
+func (c *cli) newWallet(ctx context.Context, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("new", flag.ContinueOnError)
+	curveName := fs.String("curve", utils.CURVE_P256, "Curve of the wallet key ("+utils.CURVE_P256+" or "+utils.CURVE_SECP256K1+")")
+	hd := fs.Bool("hd", false, "Create a BIP39 mnemonic and use its first BIP44 receive address")
+	if _, err := parse(fs, args, 0, "[-curve P-256|secp256k1] [-hd]"); err != nil {
+		return nil, err
+	}
+	curveSet := false
+	fs.Visit(func(f *flag.Flag) { curveSet = curveSet || f.Name == "curve" })
+	if *hd && curveSet {
+		return nil, fmt.Errorf("-hd always uses %s and cannot be combined with -curve", utils.CURVE_SECP256K1)
+	}
+	password, err := c.password()
+	if err != nil {
+		return nil, err
+	}
+
+	if *hd {
+		hdWallet, err := wallet.NewHDWallet("")
+		if err != nil {
+			return nil, err
+		}
+		return c.importHD(hdWallet, 0, 0, password)
+	}
+	curve, err := utils.CurveByName(*curveName)
+	if err != nil {
+		return nil, err
+	}
+	w, err := c.keystore.Create(password, curve)
+	if err != nil {
+		return nil, err
+	}
+	return newWalletResult(w), nil
+}
+
+func (c *cli) importHD(hdWallet *wallet.HDWallet, account uint32, index uint32, password string) (*walletResult, error) {
+	w, err := hdWallet.Wallet(account, wallet.BIP44_EXTERNAL, index)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.keystore.Import(w, password); err != nil {
+		return nil, err
+	}
+	r := newWalletResult(w)
+	r.Mnemonic = hdWallet.Mnemonic()
+	r.Path = fmt.Sprintf("m/%d'/%d'/%d'/%d/%d", wallet.BIP44_PURPOSE, wallet.BIP44_COIN_TYPE, account, wallet.BIP44_EXTERNAL, index)
+	return r, nil
+}
+
+// addresses address keystoreのwalletのアドレス
+func (c *cli) addresses(ctx context.Context, args []string) (interface{}, error) {
+	if _, err := parse(flag.NewFlagSet("address", flag.ContinueOnError), args, 0, ""); err != nil {
+		return nil, err
+	}
+	addresses, err := c.keystore.Addresses()
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		Addresses []string `json:"addresses"`
+	}{addresses}, nil
+}
+
+// balance balance <address>
+func (c *cli) balance(ctx context.Context, args []string) (interface{}, error) {
+	rest, err := parse(flag.NewFlagSet("balance", flag.ContinueOnError), args, 1, "<address>")
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.ValidateAddress(rest[0]); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, COMMAND_TIMEOUT)
+	defer cancel()
+	return c.gateway.Balance(ctx, rest[0])
+}
+
+// send send -from <address> -to <address> -amount <value>
+func (c *cli) send(ctx context.Context, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	from := fs.String("from", "", "Sender address (a wallet in the keystore)")
+	to := fs.String("to", "", "Recipient address")
+	amount := fs.String("amount", "", "Amount to send")
+	if _, err := parse(fs, args, 0, "-from <address> -to <address> -amount <value>"); err != nil {
+		return nil, err
+	}
+	if err := utils.ValidateAddress(*to); err != nil {
+		return nil, err
+	}
+	value, err := utils.ParseAmount(*amount)
+	if err != nil {
+		return nil, err
+	}
+	if value <= 0 {
+		return nil, fmt.Errorf("amount must be positive: %s", *amount)
+	}
+	w, err := c.unlock(*from)
+	if err != nil {
+		return nil, err
+	}
+	// passwordの入力とscryptの後から時間を計る
+	ctx, cancel := context.WithTimeout(ctx, COMMAND_TIMEOUT)
+	defer cancel()
+	id, err := c.gateway.Send(ctx, w, *to, value)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		Message   string       `json:"message"`
+		ID        string       `json:"id"`
+		Sender    string       `json:"sender_blockchain_address"`
+		Recipient string       `json:"recipient_blockchain_address"`
+		Value     utils.Amount `json:"value"`
+	}{"success", id, *from, *to, value}, nil
+}
+
+// history history [-offset N] [-limit N] <address> 新しい順
+func (c *cli) history(ctx context.Context, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	offset := fs.Int("offset", 0, "Number of newest transactions to skip")
+	limit := fs.Int("limit", 0, "Maximum number of transactions (server default if 0)")
+	rest, err := parse(fs, args, 1, "[-offset N] [-limit N] <address>")
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.ValidateAddress(rest[0]); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, COMMAND_TIMEOUT)
+	defer cancel()
+	return c.gateway.AddressTransactions(ctx, rest[0], *offset, *limit)
+}
+
+// export export <address> 暗号化したままのkeystoreのJSON
+func (c *cli) export(ctx context.Context, args []string) (interface{}, error) {
+	rest, err := parse(flag.NewFlagSet("export", flag.ContinueOnError), args, 1, "<address>")
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.keystore.Export(rest[0])
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(data), nil
+}
+
+// importWallet import [-mnemonic-file FILE|-] [-account N] [-index N] [file|-]
+// exportしたkeystoreのJSON（passwordで確認する）か、リカバリーフレーズからBIP44のwalletを復元する
+// リカバリーフレーズはpsや/procから他のユーザーに見えないように、引数ではなくファイルか標準入力から読む
+func (c *cli) importWallet(ctx context.Context, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	mnemonicFile := fs.String("mnemonic-file", "", "File containing the BIP39 mnemonic to restore from (- for standard input)")
+	account := fs.Uint("account", 0, "BIP44 account (with -mnemonic-file)")
+	index := fs.Uint("index", 0, "BIP44 receive address index (with -mnemonic-file)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *mnemonicFile != "" {
+		if fs.NArg() != 0 {
+			return nil, fmt.Errorf("usage: walletcli import -mnemonic-file FILE|- [-account N] [-index N]")
+		}
+		mnemonic, err := c.readMnemonic(*mnemonicFile)
+		if err != nil {
+			return nil, err
+		}
+		hdWallet, err := wallet.RestoreHDWallet(mnemonic, "")
+		if err != nil {
+			return nil, err
+		}
+		password, err := c.password()
+		if err != nil {
+			return nil, err
+		}
+		r, err := c.importHD(hdWallet, uint32(*account), uint32(*index), password)
+		if err != nil {
+			return nil, err
+		}
+		r.Mnemonic = "" // 入力されたものなので出力しない
+		return r, nil
+	}
+
+	if fs.NArg() > 1 {
+		return nil, fmt.Errorf("usage: walletcli import [file|-]")
+	}
+	var data []byte
+	var err error
+	if fs.NArg() == 0 || fs.Arg(0) == "-" {
+		if err := c.requirePasswordNotOnStdin("keystore JSON"); err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(c.stdin)
+	} else {
+		data, err = os.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		return nil, err
+	}
+	password, err := c.password()
+	if err != nil {
+		return nil, err
+	}
+	w, err := c.keystore.ImportFile(data, password)
+	if err != nil {
+		return nil, err
+	}
+	return newWalletResult(w), nil
+}
+
+// readMnemonic pathのファイル（"-"の場合は標準入力）のリカバリーフレーズ。単語の間の空白や改行は1つの空白にする
+func (c *cli) readMnemonic(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		if err := c.requirePasswordNotOnStdin("mnemonic"); err != nil {
+			return "", err
+		}
+		data, err = io.ReadAll(c.stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(string(data)), " "), nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go_blockchain/client"
+	"go_blockchain/utils"
+	"go_blockchain/wallet"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// walletcli
+// keystoreのwalletを使い、ブロックチェーンサーバー（gateway）と直接やり取りするコマンド
+// 結果はすべてJSONで標準出力に、エラーは {"message":"fail","code":...,"reason":...} で標準エラー出力に書く
+
+const (
+	PASSWORD_ENV    = "WALLETCLI_PASSWORD" // keystoreのpassword（指定しない場合は標準入力から1行読む。端末の場合は表示しない）
+	DEFAULT_GATEWAY = "http://127.0.0.1:5001"
+	COMMAND_TIMEOUT = 30 * time.Second // gatewayとのやり取りにかける時間（passwordの入力やkeystoreの復号の時間は含めない）
+)
+
+const usage = `Usage: walletcli [-gateway URL] [-keystore DIR] <command> [arguments]
+
+Commands:
+  new      [-curve P-256|secp256k1] [-hd]  create a wallet in the keystore
+  address                                  list wallet addresses in the keystore
+  balance  <address>                       confirmed and pending balance
+  send     -from <address> -to <address> -amount <value>
+  history  [-offset N] [-limit N] <address>
+  export   <address>                       print the encrypted keystore JSON
+  import   [-mnemonic-file FILE|-] [-account N] [-index N] [file|-]
+           import an exported keystore JSON, or restore a BIP44 wallet from a mnemonic
+
+The keystore password is read from $` + PASSWORD_ENV + `, -password-file, or one line of standard input.
+When the keystore JSON or the mnemonic is read from standard input, set $` + PASSWORD_ENV + ` or -password-file.
+`
+
+// cli コマンドに共通の設定
+type cli struct {
+	gateway      *client.Client
+	keystore     *wallet.Keystore
+	passwordFile string
+	stdin        *bufio.Reader
+}
+
+func main() {
+	home, _ := os.UserHomeDir()
+	gateway := flag.String("gateway", DEFAULT_GATEWAY, "Blockchain Gateway")
+	keystoreDir := flag.String("keystore", filepath.Join(home, ".go_blockchain", "keystore"), "Directory of encrypted wallet keystore files")
+	passwordFile := flag.String("password-file", "", "File containing the keystore password")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	keystore, err := wallet.NewKeystore(*keystoreDir)
+	if err != nil {
+		fail(err)
+	}
+	c := &cli{
+		gateway:      client.NewClient(*gateway),
+		keystore:     keystore,
+		passwordFile: *passwordFile,
+		stdin:        bufio.NewReader(os.Stdin),
+	}
+
+	commands := map[string]func(context.Context, []string) (interface{}, error){
+		"new":     c.newWallet,
+		"address": c.addresses,
+		"balance": c.balance,
+		"send":    c.send,
+		"history": c.history,
+		"export":  c.export,
+		"import":  c.importWallet,
+	}
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+	result, err := command(context.Background(), flag.Args()[1:])
+	if err != nil {
+		fail(err)
+	}
+	if raw, ok := result.(json.RawMessage); ok {
+		os.Stdout.Write(append(raw, '\n'))
+		return
+	}
+	m, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(m))
+}
+
+// password keystoreのpassword
+func (c *cli) password() (string, error) {
+	if p := os.Getenv(PASSWORD_ENV); p != "" {
+		return p, nil
+	}
+	if c.passwordFile != "" {
+		data, err := os.ReadFile(c.passwordFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	// 端末から入力する場合は、入力したpasswordを画面に表示しない
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		p, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("cannot read password: %w", err)
+		}
+		return string(p), nil
+	}
+	line, err := c.stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("cannot read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// requirePasswordNotOnStdin
+// 標準入力をpassword以外（keystoreのJSONやmnemonic）に使う場合は、passwordを環境変数か -password-file で指定させる
+func (c *cli) requirePasswordNotOnStdin(input string) error {
+	if os.Getenv(PASSWORD_ENV) == "" && c.passwordFile == "" {
+		return fmt.Errorf("set %s or -password-file when reading the %s from standard input", PASSWORD_ENV, input)
+	}
+	return nil
+}
+
+// unlock keystoreのwalletをpasswordで復号する
+func (c *cli) unlock(blockchainAddress string) (*wallet.Wallet, error) {
+	password, err := c.password()
+	if err != nil {
+		return nil, err
+	}
+	return c.keystore.Unlock(blockchainAddress, password)
+}
+
+// parse サブコマンドのflagを読み、残りの引数がnArgs個であることを確認する
+func parse(fs *flag.FlagSet, args []string, nArgs int, argsUsage string) ([]string, error) {
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != nArgs {
+		return nil, fmt.Errorf("usage: walletcli %s %s", fs.Name(), argsUsage)
+	}
+	return fs.Args(), nil
+}
+
+// fail エラーをJSONで標準エラー出力に書いて終了する
+func fail(err error) {
+	code := utils.ERROR_INVALID_PARAMETER
+	var ae *client.APIError
+	var te *client.TransportError
+	switch {
+	case errors.As(err, &ae) && ae.Code != "":
+		code = ae.Code
+	case errors.As(err, &ae):
+		code = utils.ERROR_BAD_GATEWAY
+	case errors.As(err, &te) && te.Timeout():
+		code = utils.ERROR_GATEWAY_TIMEOUT
+	case errors.As(err, &te):
+		code = utils.ERROR_BAD_GATEWAY
+	case errors.Is(err, wallet.ErrWrongPassword):
+		code = utils.ERROR_UNAUTHORIZED
+	case errors.Is(err, wallet.ErrWalletNotFound):
+		code = utils.ERROR_NOT_FOUND
+	case errors.Is(err, wallet.ErrWalletExists):
+		code = utils.ERROR_CONFLICT
+	}
+	os.Stderr.Write(append(utils.JsonError(code, err.Error()), '\n'))
+	os.Exit(1)
+}
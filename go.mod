@@ -4,5 +4,9 @@ go 1.17
 
 require (
 	github.com/btcsuite/btcutil v1.0.2
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.3.0
 	golang.org/x/crypto v0.0.0-20220408190544-5352b0902921
+	golang.org/x/term v0.10.0
 )
+
+require golang.org/x/sys v0.10.0 // indirect
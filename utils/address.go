@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/ripemd160"
+)
+
+const ADDRESS_VERSION = 0x00 // Main Network
+
+var ErrInvalidAddress = errors.New("invalid blockchain address")
+
+// AddressFromPublicKey
+// publicKeyから決まった手順でblockchainAddressを作成
+// secp256k1の鍵はSEC1の圧縮形式をハッシュするので、同じ鍵のBitcoinのアドレスと一致する
+func AddressFromPublicKey(publicKey *ecdsa.PublicKey) string {
+	// 2. Perform SHA-256 hashing on the public key (32 bytes).
+	h2 := sha256.New()
+	if publicKey.Curve == Secp256k1() {
+		h2.Write(elliptic.MarshalCompressed(publicKey.Curve, publicKey.X, publicKey.Y))
+	} else {
+		h2.Write(publicKey.X.Bytes())
+		h2.Write(publicKey.Y.Bytes())
+	}
+	digest2 := h2.Sum(nil)
+	// 3. Perform RIPEMD-160 hashing on the result of SHA-256 (20 bytes).
+	h3 := ripemd160.New()
+	h3.Write(digest2)
+	digest3 := h3.Sum(nil)
+	// 4. Add version byte in front of RIPEMD-160 hash (0x00 for Main Network).
+	vd4 := make([]byte, 21)
+	vd4[0] = ADDRESS_VERSION
+	copy(vd4[1:], digest3[:])
+	// 5-7. Take the first 4 bytes of the double SHA-256 hash for checksum.
+	chsum := addressChecksum(vd4)
+	// 8. Add the 4 checksum bytes from 7 at the end of extended RIPEMD-160 hash from 4 (25 bytes).
+	dc8 := make([]byte, 25)
+	copy(dc8[:21], vd4[:])
+	copy(dc8[21:], chsum[:])
+	// 9. Convert the result from a byte string into base58.
+	return base58.Encode(dc8)
+}
+
+// ValidateAddress Base58Checkの形式・バージョン・チェックサムが正しいか
+func ValidateAddress(address string) error {
+	dc := base58.Decode(address)
+	if len(dc) != 25 {
+		return fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+	if dc[0] != ADDRESS_VERSION {
+		return fmt.Errorf("%w: %q has unknown version %d", ErrInvalidAddress, address, dc[0])
+	}
+	if !bytes.Equal(addressChecksum(dc[:21]), dc[21:]) {
+		return fmt.Errorf("%w: %q has a bad checksum", ErrInvalidAddress, address)
+	}
+	return nil
+}
+
+// addressChecksum SHA-256を2回かけた結果の先頭4バイト
+func addressChecksum(payload []byte) []byte {
+	h5 := sha256.Sum256(payload)
+	h6 := sha256.Sum256(h5[:])
+	return h6[:4]
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	AMOUNT_DECIMALS = 8                 // 小数点以下の桁数
+	AMOUNT_UNIT     = Amount(100000000) // 1コインあたりの最小単位の数
+)
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// Amount コインの額を最小単位の整数で表す（1コイン = AMOUNT_UNIT）
+// floatの丸め誤差を避けるため、JSONでは "1.5" のような10進数の文字列にする
+type Amount int64
+
+// ParseAmount
+// "1.5"のような10進数の文字列を読み込む
+// 負の数・NaN・指数表記・小数点以下AMOUNT_DECIMALS桁を超えるもの・int64に収まらないものはエラー
+func ParseAmount(s string) (Amount, error) {
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+		if fracPart == "" {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+		}
+	}
+	if intPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if len(fracPart) > AMOUNT_DECIMALS {
+		return 0, fmt.Errorf("%w: %q has more than %d decimal places", ErrInvalidAmount, s, AMOUNT_DECIMALS)
+	}
+
+	i, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil || i > math.MaxInt64/int64(AMOUNT_UNIT) {
+		return 0, fmt.Errorf("%w: %q is too large", ErrInvalidAmount, s)
+	}
+	var f int64
+	if fracPart != "" {
+		f, _ = strconv.ParseInt(fracPart+strings.Repeat("0", AMOUNT_DECIMALS-len(fracPart)), 10, 64)
+	}
+	v := i*int64(AMOUNT_UNIT) + f
+	if v < 0 {
+		return 0, fmt.Errorf("%w: %q is too large", ErrInvalidAmount, s)
+	}
+	return Amount(v), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String 末尾の0を省いた10進数の文字列
+func (a Amount) String() string {
+	sign := ""
+	u := uint64(a)
+	if a < 0 {
+		sign = "-"
+		u = uint64(-a)
+	}
+	i := u / uint64(AMOUNT_UNIT)
+	f := u % uint64(AMOUNT_UNIT)
+	if f == 0 {
+		return fmt.Sprintf("%s%d", sign, i)
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%0*d", AMOUNT_DECIMALS, f), "0")
+	return fmt.Sprintf("%s%d.%s", sign, i, frac)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON
+// 文字列の "1.5" の他に、数値の 1.5 も受け付ける（floatを経由せずに読み込む）
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(bytes.TrimSpace(data))
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
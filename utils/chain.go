@@ -0,0 +1,15 @@
+package utils
+
+import "time"
+
+// chain全体で共通のルール
+// ノードごとに違う値を使うと、お互いのブロックや署名を受け付けなくなりchainが分かれるので、フラグなどで変えられるようにしない
+const (
+	// CHAIN_ID 別のchain向けに作った署名を使い回せないように、署名するデータに含める
+	CHAIN_ID = "go_blockchain-1"
+	// TARGET_BLOCK_TIME difficultyを調整する時の、1ブロックあたりの目標のマイニング時間
+	TARGET_BLOCK_TIME = 20 * time.Second
+	// MAX_FUTURE_BLOCK_TIME ブロックのタイムスタンプが、検証するノードの現在時刻より進んでいてよい時間
+	// タイムスタンプを先の時刻にして、difficultyを下げることを防ぐ
+	MAX_FUTURE_BLOCK_TIME = 1 * time.Minute
+)
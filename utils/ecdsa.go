@@ -1,56 +1,298 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
+	"strings"
 )
 
+// SCALAR_HEX_LENGTH 座標・署名・秘密鍵の1つの値を16進数で表した時の長さ（32バイト）
+const SCALAR_HEX_LENGTH = 64
+
+// 選べる曲線の名前
+// P-256以外の鍵は、文字列の先頭に "曲線の名前:" を付けて曲線を記録する
+const (
+	CURVE_P256      = "P-256"
+	CURVE_SECP256K1 = "secp256k1"
+	CURVE_SEPARATOR = ":"
+)
+
+var (
+	ErrInvalidPublicKey  = errors.New("invalid public key")
+	ErrInvalidPrivateKey = errors.New("invalid private key")
+	ErrInvalidSignature  = errors.New("invalid signature")
+	ErrUnknownCurve      = errors.New("unknown curve")
+)
+
+// CurveByName CURVE_P256 または CURVE_SECP256K1 の曲線
+func CurveByName(name string) (elliptic.Curve, error) {
+	switch name {
+	case CURVE_P256:
+		return elliptic.P256(), nil
+	case CURVE_SECP256K1:
+		return Secp256k1(), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCurve, name)
+	}
+}
+
+// curvePrefix 鍵の文字列の先頭に付ける曲線の名前（P-256の場合は付けない）
+func curvePrefix(curve elliptic.Curve) string {
+	if curve == elliptic.P256() {
+		return ""
+	}
+	return curve.Params().Name + CURVE_SEPARATOR
+}
+
+// splitCurve 鍵の文字列から曲線の名前を取り除き、その曲線を返す（名前がない場合はP-256）
+func splitCurve(s string) (elliptic.Curve, string, error) {
+	i := strings.Index(s, CURVE_SEPARATOR)
+	if i < 0 {
+		return elliptic.P256(), s, nil
+	}
+	curve, err := CurveByName(s[:i])
+	if err != nil {
+		return nil, "", err
+	}
+	return curve, s[i+len(CURVE_SEPARATOR):], nil
+}
+
 // Signature トランザクションの署名
 type Signature struct {
 	R *big.Int
 	S *big.Int
 }
 
+// String RとSをそれぞれ64桁に0埋めした16進数（128文字）
 func (s *Signature) String() string {
-	return fmt.Sprintf("%x%x", s.R, s.S)
+	return fmt.Sprintf("%064x%064x", s.R, s.S)
 }
 
-func String2BigIntTuple(s string) (big.Int, big.Int) {
-	bx, _ := hex.DecodeString(s[:64])
-	by, _ := hex.DecodeString(s[64:])
+// IsLowS
+// sが曲線の位数Nの半分以下か
+// (r, N-s) も同じデータに対する正しい署名になるので、片方だけを受け付けて
+// 署名を含めて計算するトランザクションIDを、他人が変えられないようにする
+func (s *Signature) IsLowS(curve elliptic.Curve) bool {
+	halfN := new(big.Int).Rsh(curve.Params().N, 1)
+	return s.S.Cmp(halfN) <= 0
+}
+
+// NormalizeLowS sが位数の半分より大きい場合は N-s にする（署名する側で使う）
+func (s *Signature) NormalizeLowS(curve elliptic.Curve) {
+	if !s.IsLowS(curve) {
+		s.S = new(big.Int).Sub(curve.Params().N, s.S)
+	}
+}
+
+// Sign
+// hashに署名する。sは位数の半分以下にする
+// secp256k1の鍵はdcrdの実装で、それ以外はcrypto/ecdsaで署名する
+func Sign(privateKey *ecdsa.PrivateKey, hash []byte) (*Signature, error) {
+	if privateKey.Curve == Secp256k1() {
+		return signSecp256k1(privateKey, hash), nil
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash)
+	if err != nil {
+		return nil, err
+	}
+	signature := &Signature{R: r, S: s}
+	signature.NormalizeLowS(privateKey.Curve)
+	return signature, nil
+}
+
+// DER ASN.1 DERで表した署名（SEQUENCE { INTEGER r, INTEGER s }）
+func (s *Signature) DER() []byte {
+	b, _ := asn1.Marshal(struct {
+		R *big.Int
+		S *big.Int
+	}{s.R, s.S})
+	return b
+}
+
+// DERString DERの16進数
+func (s *Signature) DERString() string {
+	return hex.EncodeToString(s.DER())
+}
+
+// PublicKeyString XとYをそれぞれ64桁に0埋めした16進数（128文字）
+func PublicKeyString(publicKey *ecdsa.PublicKey) string {
+	return curvePrefix(publicKey.Curve) + fmt.Sprintf("%064x%064x", publicKey.X, publicKey.Y)
+}
 
+// PublicKeyCompressedString SEC1の圧縮形式（02または03 + X）の16進数（66文字）
+func PublicKeyCompressedString(publicKey *ecdsa.PublicKey) string {
+	return curvePrefix(publicKey.Curve) +
+		hex.EncodeToString(elliptic.MarshalCompressed(publicKey.Curve, publicKey.X, publicKey.Y))
+}
+
+// PublicKeyUncompressedString SEC1の非圧縮形式（04 + X + Y）の16進数（130文字）
+func PublicKeyUncompressedString(publicKey *ecdsa.PublicKey) string {
+	return curvePrefix(publicKey.Curve) +
+		hex.EncodeToString(elliptic.Marshal(publicKey.Curve, publicKey.X, publicKey.Y))
+}
+
+// PrivateKeyString Dを64桁に0埋めした16進数
+func PrivateKeyString(privateKey *ecdsa.PrivateKey) string {
+	return curvePrefix(privateKey.Curve) + fmt.Sprintf("%064x", privateKey.D)
+}
+
+// String2BigIntTuple 128文字の16進数を、64文字ずつ2つの数に分ける
+func String2BigIntTuple(s string) (big.Int, big.Int, error) {
 	var bix big.Int
 	var biy big.Int
+	if len(s) != 2*SCALAR_HEX_LENGTH {
+		return bix, biy, fmt.Errorf("expected %d hex characters, got %d", 2*SCALAR_HEX_LENGTH, len(s))
+	}
+	bx, err := hex.DecodeString(s[:SCALAR_HEX_LENGTH])
+	if err != nil {
+		return bix, biy, err
+	}
+	by, err := hex.DecodeString(s[SCALAR_HEX_LENGTH:])
+	if err != nil {
+		return bix, biy, err
+	}
 
 	_ = bix.SetBytes(bx)
 	_ = biy.SetBytes(by)
 
-	return bix, biy
+	return bix, biy, nil
 }
 
-func SignatureFromString(s string) *Signature {
-	x, y := String2BigIntTuple(s)
-	return &Signature{&x, &y}
+// SignatureFromString
+// 次の形式を自動で判別して読み込む
+//   - DER（30で始まるASN.1のSEQUENCE）
+//   - RとSをそれぞれ64桁にした16進数（128文字）
+//
+// RとSが1以上、256ビット以内であることも確認する（曲線の位数未満かはecdsa.Verifyで、
+// Sが位数の半分以下か（IsLowS）は公開鍵の曲線が分かる署名の検証で確認する）
+func SignatureFromString(s string) (*Signature, error) {
+	var r, ss big.Int
+	if sig, ok := parseDERSignature(s); ok {
+		r, ss = *sig.R, *sig.S
+	} else {
+		var err error
+		r, ss, err = String2BigIntTuple(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+	}
+	if r.Sign() <= 0 || ss.Sign() <= 0 || r.BitLen() > 4*SCALAR_HEX_LENGTH || ss.BitLen() > 4*SCALAR_HEX_LENGTH {
+		return nil, fmt.Errorf("%w: r or s is out of range", ErrInvalidSignature)
+	}
+	return &Signature{&r, &ss}, nil
 }
 
-func PublicKeyFromString(s string) *ecdsa.PublicKey {
-	x, y := String2BigIntTuple(s)
-	return &ecdsa.PublicKey{
-		Curve: elliptic.P256(),
-		X:     &x,
-		Y:     &y,
+// parseDERSignature sがDERの署名として正しい場合だけtrue
+// 余分なバイトや、DERとして一意でない表し方（BER）は受け付けない
+func parseDERSignature(s string) (*Signature, bool) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) == 0 || b[0] != 0x30 {
+		return nil, false
+	}
+	var sig Signature
+	rest, err := asn1.Unmarshal(b, &sig)
+	if err != nil || len(rest) != 0 || sig.R == nil || sig.S == nil {
+		return nil, false
+	}
+	if !bytes.Equal(sig.DER(), b) {
+		return nil, false
 	}
+	return &sig, true
 }
 
-func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) *ecdsa.PrivateKey {
-	b, _ := hex.DecodeString(s[:])
+// PublicKeyFromString
+// 次の形式を自動で判別して読み込む
+//   - XとYをそれぞれ64桁にした16進数（128文字）
+//   - SEC1の非圧縮形式（04 + X + Y、130文字）
+//   - SEC1の圧縮形式（02または03 + X、66文字）
+//
+// 先頭に "secp256k1:" がある場合はsecp256k1、ない場合はP-256の鍵として読み込む
+// 点が曲線上にあることも確認する
+func PublicKeyFromString(s string) (*ecdsa.PublicKey, error) {
+	curve, s, err := splitCurve(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+	var x, y *big.Int
+	switch len(s) {
+	case 2 * SCALAR_HEX_LENGTH:
+		bx, by, err := String2BigIntTuple(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+		x, y = &bx, &by
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("%w: point is not on the curve", ErrInvalidPublicKey)
+		}
+	case 2 + 2*SCALAR_HEX_LENGTH, 2 + SCALAR_HEX_LENGTH:
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+		switch b[0] {
+		case 0x04:
+			x, y = elliptic.Unmarshal(curve, b)
+		case 0x02, 0x03:
+			if curve == Secp256k1() {
+				x, y = unmarshalSecp256k1Compressed(b)
+			} else {
+				x, y = elliptic.UnmarshalCompressed(curve, b)
+			}
+		}
+		// 形式が違う場合や、点が曲線上にない場合はnil
+		if x == nil {
+			return nil, fmt.Errorf("%w: invalid SEC1 encoding", ErrInvalidPublicKey)
+		}
+	default:
+		return nil, fmt.Errorf("%w: expected %d, %d or %d hex characters, got %d", ErrInvalidPublicKey,
+			2*SCALAR_HEX_LENGTH, 2+2*SCALAR_HEX_LENGTH, 2+SCALAR_HEX_LENGTH, len(s))
+	}
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     x,
+		Y:     y,
+	}, nil
+}
+
+// PrivateKeyFromString 曲線がpublicKeyと同じで、秘密鍵からpublicKeyが導けることも確認する
+func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) (*ecdsa.PrivateKey, error) {
+	curve, s, err := splitCurve(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPrivateKey, err)
+	}
+	if curve != publicKey.Curve {
+		return nil, fmt.Errorf("%w: curve does not match the public key", ErrInvalidPrivateKey)
+	}
+	if len(s) != SCALAR_HEX_LENGTH {
+		return nil, fmt.Errorf("%w: expected %d hex characters, got %d", ErrInvalidPrivateKey, SCALAR_HEX_LENGTH, len(s))
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPrivateKey, err)
+	}
 	var bi big.Int
 	_ = bi.SetBytes(b)
+	if !inScalarRange(&bi, curve.Params().N) {
+		return nil, fmt.Errorf("%w: out of range", ErrInvalidPrivateKey)
+	}
+	x, y := curve.ScalarBaseMult(b)
+	if x.Cmp(publicKey.X) != 0 || y.Cmp(publicKey.Y) != 0 {
+		return nil, fmt.Errorf("%w: does not match the public key", ErrInvalidPrivateKey)
+	}
 	return &ecdsa.PrivateKey{
 		PublicKey: *publicKey,
 		D:         &bi,
-	}
+	}, nil
+}
+
+// inScalarRange 1 <= v < n
+func inScalarRange(v *big.Int, n *big.Int) bool {
+	return v.Sign() > 0 && v.Cmp(n) < 0
 }
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode エラーレスポンスの種類（クライアントはreasonの文言ではなくcodeで判断する）
+type ErrorCode string
+
+const (
+	ERROR_INVALID_JSON           ErrorCode = "invalid_json"           // 400 JSONとして読めない
+	ERROR_INVALID_PARAMETER      ErrorCode = "invalid_parameter"      // 400 パスやクエリ、公開鍵や署名の形式が正しくない
+	ERROR_UNAUTHORIZED           ErrorCode = "unauthorized"           // 401
+	ERROR_FORBIDDEN              ErrorCode = "forbidden"              // 403
+	ERROR_NOT_FOUND              ErrorCode = "not_found"              // 404
+	ERROR_METHOD_NOT_ALLOWED     ErrorCode = "method_not_allowed"     // 405
+	ERROR_CONFLICT               ErrorCode = "conflict"               // 409
+	ERROR_REQUEST_TOO_LARGE      ErrorCode = "request_too_large"      // 413
+	ERROR_UNSUPPORTED_MEDIA_TYPE ErrorCode = "unsupported_media_type" // 415
+	ERROR_INVALID_FIELD          ErrorCode = "invalid_field"          // 422 必要な項目がない・値が正しくない
+	ERROR_REJECTED               ErrorCode = "rejected"               // 422 ブロックチェーンのルールで受け付けられない
+	ERROR_LOCKED                 ErrorCode = "locked"                 // 423
+	ERROR_BAD_GATEWAY            ErrorCode = "bad_gateway"            // 502 ブロックチェーンサーバーとの通信に失敗
+	ERROR_UNAVAILABLE            ErrorCode = "unavailable"            // 503 混み合っていて処理できない（Retry-After後にやり直す）
+	ERROR_GATEWAY_TIMEOUT        ErrorCode = "gateway_timeout"        // 504 ブロックチェーンサーバーが時間内に応答しない
+	ERROR_INTERNAL               ErrorCode = "internal"               // 500
+)
+
+// HTTPError ステータスとエラーの種類を持つエラー
+type HTTPError struct {
+	Status int
+	Code   ErrorCode
+	Reason string
+}
+
+func NewHTTPError(status int, code ErrorCode, reason string) *HTTPError {
+	return &HTTPError{status, code, reason}
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Reason)
+}
+
+// WriteJson statusとJSONのbodyを書き込む
+func WriteJson(w http.ResponseWriter, status int, m []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(m)
+}
+
+// WriteError エラーをログに出し、JsonErrorのbodyで返す
+func WriteError(w http.ResponseWriter, status int, code ErrorCode, reason string) {
+	log.Printf("ERROR: %s: %s", code, reason)
+	WriteJson(w, status, JsonError(code, reason))
+}
+
+// WriteHTTPError errが*HTTPErrorの場合はそのstatusとcode、それ以外は500で返す
+func WriteHTTPError(w http.ResponseWriter, err error) {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		WriteError(w, he.Status, he.Code, he.Reason)
+		return
+	}
+	WriteError(w, http.StatusInternalServerError, ERROR_INTERNAL, err.Error())
+}
+
+// MethodNotAllowed 405とAllowヘッダーで受け付けるメソッドを返す
+func MethodNotAllowed(w http.ResponseWriter, req *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	WriteError(w, http.StatusMethodNotAllowed, ERROR_METHOD_NOT_ALLOWED,
+		fmt.Sprintf("method %s is not allowed", req.Method))
+}
+
+// DecodeJsonBody
+// Content-Typeがapplication/jsonで、maxBytes以下のJSONのbodyだけをvに読み込む
+// Content-Typeがない場合も415にする（HTMLのformなど、JSONを送るつもりのないリクエストを受け付けない）
+// 失敗した場合は*HTTPError（415, 413, 400）を返す
+func DecodeJsonBody(w http.ResponseWriter, req *http.Request, v interface{}, maxBytes int64) error {
+	ct := req.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil || mediaType != "application/json" {
+		return NewHTTPError(http.StatusUnsupportedMediaType, ERROR_UNSUPPORTED_MEDIA_TYPE,
+			fmt.Sprintf("Content-Type must be application/json, got %q", ct))
+	}
+	if req.ContentLength > maxBytes {
+		return requestTooLarge(maxBytes)
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxBytes))
+	if err := decoder.Decode(v); err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			return requestTooLarge(maxBytes)
+		}
+		return NewHTTPError(http.StatusBadRequest, ERROR_INVALID_JSON, err.Error())
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return NewHTTPError(http.StatusBadRequest, ERROR_INVALID_JSON, "request body must contain a single JSON value")
+	}
+	return nil
+}
+
+func requestTooLarge(maxBytes int64) *HTTPError {
+	return NewHTTPError(http.StatusRequestEntityTooLarge, ERROR_REQUEST_TOO_LARGE,
+		fmt.Sprintf("request body must not exceed %d bytes", maxBytes))
+}
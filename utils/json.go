@@ -10,3 +10,17 @@ func JsonStatus(message string) []byte {
 	})
 	return m
 }
+
+// JsonError 失敗したレスポンス {"message":"fail","code":...,"reason":...}
+func JsonError(code ErrorCode, reason string) []byte {
+	m, _ := json.Marshal(struct {
+		Message string    `json:"message"`
+		Code    ErrorCode `json:"code"`
+		Reason  string    `json:"reason"`
+	}{
+		Message: "fail",
+		Code:    code,
+		Reason:  reason,
+	})
+	return m
+}
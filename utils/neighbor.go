@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// IsFoundHost 指定したhost:portにTCPで接続できるか
+func IsFoundHost(host string, port uint16) bool {
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	conn, err := net.DialTimeout("tcp", target, 1*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// FindNeighbors
+// 同じhostのstartPort〜endPortを走査して、自分以外に応答したノードのアドレスを返す
+func FindNeighbors(myHost string, myPort uint16, startPort uint16, endPort uint16) []string {
+	neighbors := make([]string, 0)
+	for port := startPort; port <= endPort; port++ {
+		if port != myPort && IsFoundHost(myHost, port) {
+			neighbors = append(neighbors, net.JoinHostPort(myHost, strconv.Itoa(int(port))))
+		}
+		if port == endPort { // uint16のオーバーフロー対策
+			break
+		}
+	}
+	return neighbors
+}
+
+// ParseNeighbor "host:port"形式のアドレスを分解する
+func ParseNeighbor(neighbor string) (string, uint16, error) {
+	host, p, err := net.SplitHostPort(neighbor)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q: %v", p, err)
+	}
+	return host, uint16(port), nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secp256k1ecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// secp256k1Curve
+// Bitcoinと同じ曲線 y² = x³ + 7
+// elliptic.CurveParamsの計算は a = -3 の曲線用なので使えない
+// 点の計算はdcrd（Decred）の実装に任せ、自分では行わない
+type secp256k1Curve struct {
+	*secp256k1.KoblitzCurve
+}
+
+var secp256k1Instance = &secp256k1Curve{secp256k1.S256()}
+
+// Secp256k1 secp256k1のelliptic.Curve
+func Secp256k1() elliptic.Curve {
+	return secp256k1Instance
+}
+
+// IsOnCurve
+// dcrdの実装は座標をPで割った余りにしてから確かめるので、
+// 同じ点を別の数で表した公開鍵を受け付けないように、座標がP未満であることも確認する
+func (c *secp256k1Curve) IsOnCurve(x, y *big.Int) bool {
+	p := c.Params().P
+	if x.Sign() < 0 || x.Cmp(p) >= 0 || y.Sign() < 0 || y.Cmp(p) >= 0 {
+		return false
+	}
+	return c.KoblitzCurve.IsOnCurve(x, y)
+}
+
+// unmarshalSecp256k1Compressed SEC1の圧縮形式から点を復元する
+// elliptic.UnmarshalCompressedは a = -3 の曲線の式を使うので、secp256k1には使えない
+func unmarshalSecp256k1Compressed(data []byte) (*big.Int, *big.Int) {
+	if len(data) != 33 || (data[0] != 2 && data[0] != 3) {
+		return nil, nil
+	}
+	publicKey, err := secp256k1.ParsePubKey(data)
+	if err != nil {
+		return nil, nil
+	}
+	return publicKey.X(), publicKey.Y()
+}
+
+// signSecp256k1
+// RFC 6979でkを決め、sを位数の半分以下にした署名
+// crypto/ecdsaはsecp256k1の秘密鍵を汎用の（定数時間でない）計算で扱うので使わない
+func signSecp256k1(privateKey *ecdsa.PrivateKey, hash []byte) *Signature {
+	var d secp256k1.ModNScalar
+	d.SetByteSlice(privateKey.D.Bytes())
+	key := secp256k1.NewPrivateKey(&d)
+	defer key.Zero()
+
+	sig := secp256k1ecdsa.Sign(key, hash)
+	r, s := sig.R(), sig.S()
+	rb, sb := r.Bytes(), s.Bytes()
+	return &Signature{
+		R: new(big.Int).SetBytes(rb[:]),
+		S: new(big.Int).SetBytes(sb[:]),
+	}
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// TRANSACTION_SIGNING_DOMAIN 他の用途の署名と取り違えないように、署名するデータの先頭に付ける（CHAIN_IDはchain.go）
+const TRANSACTION_SIGNING_DOMAIN = "go_blockchain/transaction/v1"
+
+// TransactionSigningPayload
+// トランザクションの署名の対象となるバイト列
+// walletとブロックチェーンサーバーの両方がこれを使うことで、署名の対象が食い違わないようにする
+// 形式（文字列は4バイトのbig endianの長さ + 中身、数値は8バイトのbig endian）:
+//
+//	domain | chain_id | sender | recipient | value | nonce
+func TransactionSigningPayload(chainID string, sender string, recipient string, value Amount, nonce uint64) []byte {
+	buf := make([]byte, 0, 4*4+len(TRANSACTION_SIGNING_DOMAIN)+len(chainID)+len(sender)+len(recipient)+8+8)
+	buf = appendString(buf, TRANSACTION_SIGNING_DOMAIN)
+	buf = appendString(buf, chainID)
+	buf = appendString(buf, sender)
+	buf = appendString(buf, recipient)
+	buf = appendUint64(buf, uint64(value))
+	buf = appendUint64(buf, nonce)
+	return buf
+}
+
+// TransactionSigningHash TransactionSigningPayloadのsha256（ECDSAで署名する値）
+func TransactionSigningHash(chainID string, sender string, recipient string, value Amount, nonce uint64) [sha256.Size]byte {
+	return sha256.Sum256(TransactionSigningPayload(chainID, sender, recipient, value, nonce))
+}
+
+func appendString(buf []byte, s string) []byte {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(s)))
+	buf = append(buf, l[:]...)
+	return append(buf, s...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
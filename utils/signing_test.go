@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// walletとブロックチェーンサーバーが同じバイト列に署名していることを、固定の入力と期待値で確かめる
+// 期待値はこのパッケージとは別に、形式（長さ付きの文字列と8バイトのbig endian）から計算したもの
+var signingVectors = []struct {
+	name      string
+	chainID   string
+	sender    string
+	recipient string
+	value     Amount
+	nonce     uint64
+	payload   string
+	hash      string
+}{
+	{
+		name:      "transfer",
+		chainID:   CHAIN_ID,
+		sender:    "1LqBGSKuX5yYUonjxT5qGfpUsXKYYWeabA",
+		recipient: "1B4Vr4T9jPoFBdDnq6wqjhTr6BXF63Dgvi",
+		value:     150000000, // 1.5
+		nonce:     7,
+		payload: "0000001c676f5f626c6f636b636861696e2f7472616e73616374696f6e2f7631" +
+			"0000000f676f5f626c6f636b636861696e2d31" +
+			"00000022314c714247534b7558357959556f6e6a785435714766705573584b59595765616241" +
+			"0000002231423456723454396a506f464264446e713677716a685472364258463633446776" + "69" +
+			"0000000008f0d180" +
+			"0000000000000007",
+		hash: "1320dacf0668ad8e489411e127d5bba0e93e7671fd62bda7453d2ffaf699449c",
+	},
+	{
+		name:    "empty",
+		chainID: CHAIN_ID,
+		payload: "0000001c676f5f626c6f636b636861696e2f7472616e73616374696f6e2f7631" +
+			"0000000f676f5f626c6f636b636861696e2d31" +
+			"00000000" +
+			"00000000" +
+			"0000000000000000" +
+			"0000000000000000",
+		hash: "fda1d0a38e1eaeb939599fa7c9d351bff32101d40db46900eeb1ad19d0da1797",
+	},
+}
+
+func TestTransactionSigningPayload(t *testing.T) {
+	for _, v := range signingVectors {
+		got := hex.EncodeToString(TransactionSigningPayload(v.chainID, v.sender, v.recipient, v.value, v.nonce))
+		if got != v.payload {
+			t.Errorf("%s: payload\n got  %s\n want %s", v.name, got, v.payload)
+		}
+	}
+}
+
+func TestTransactionSigningHash(t *testing.T) {
+	for _, v := range signingVectors {
+		h := TransactionSigningHash(v.chainID, v.sender, v.recipient, v.value, v.nonce)
+		if got := hex.EncodeToString(h[:]); got != v.hash {
+			t.Errorf("%s: hash\n got  %s\n want %s", v.name, got, v.hash)
+		}
+	}
+}
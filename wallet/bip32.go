@@ -0,0 +1,215 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go_blockchain/utils"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// BIP32
+// secp256k1の鍵をseedから階層的に導出する
+// cf. https://github.com/bitcoin/bips/blob/master/bip-0032.mediawiki
+
+const HARDENED_KEY_START = 0x80000000 // これ以上のindexはhardened（親の公開鍵からは導出できない）
+
+var (
+	xprvVersion = [4]byte{0x04, 0x88, 0xad, 0xe4}
+	xpubVersion = [4]byte{0x04, 0x88, 0xb2, 0x1e}
+)
+
+var (
+	ErrInvalidSeed     = errors.New("seed must be 16-64 bytes")
+	ErrInvalidChildKey = errors.New("invalid child key, use the next index")
+	ErrHardenedFromPub = errors.New("cannot derive a hardened key from a public key")
+	ErrInvalidPath     = errors.New("invalid derivation path")
+)
+
+// ExtendedKey 鍵と、子の鍵を導出するためのchain code
+type ExtendedKey struct {
+	privateKey        *big.Int // 公開鍵だけの場合はnil
+	x, y              *big.Int
+	chainCode         []byte
+	depth             uint8
+	parentFingerprint [4]byte
+	childNumber       uint32
+}
+
+// NewMasterKey seedからマスター鍵（m）を作る
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, ErrInvalidSeed
+	}
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	i := mac.Sum(nil)
+	k := new(big.Int).SetBytes(i[:32])
+	if k.Sign() == 0 || k.Cmp(utils.Secp256k1().Params().N) >= 0 {
+		return nil, ErrInvalidSeed
+	}
+	return newPrivateExtendedKey(k, i[32:], 0, [4]byte{}, 0), nil
+}
+
+func newPrivateExtendedKey(k *big.Int, chainCode []byte, depth uint8, parentFingerprint [4]byte, childNumber uint32) *ExtendedKey {
+	x, y := utils.Secp256k1().ScalarBaseMult(padScalar(k))
+	return &ExtendedKey{k, x, y, chainCode, depth, parentFingerprint, childNumber}
+}
+
+func (ek *ExtendedKey) IsPrivate() bool {
+	return ek.privateKey != nil
+}
+
+// Child index番目の子の鍵（hardenedの場合は HARDENED_KEY_START + index）
+func (ek *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	curve := utils.Secp256k1()
+	hardened := index >= HARDENED_KEY_START
+	if hardened && !ek.IsPrivate() {
+		return nil, ErrHardenedFromPub
+	}
+
+	var data []byte
+	if hardened {
+		data = append([]byte{0x00}, padScalar(ek.privateKey)...)
+	} else {
+		data = ek.compressedPublicKey()
+	}
+	data = append(data, uint32Bytes(index)...)
+	mac := hmac.New(sha512.New, ek.chainCode)
+	mac.Write(data)
+	i := mac.Sum(nil)
+
+	il := new(big.Int).SetBytes(i[:32])
+	n := curve.Params().N
+	if il.Cmp(n) >= 0 {
+		return nil, ErrInvalidChildKey
+	}
+	fingerprint := ek.fingerprint()
+	if ek.IsPrivate() {
+		k := new(big.Int).Add(il, ek.privateKey)
+		k.Mod(k, n)
+		if k.Sign() == 0 {
+			return nil, ErrInvalidChildKey
+		}
+		return newPrivateExtendedKey(k, i[32:], ek.depth+1, fingerprint, index), nil
+	}
+	ilx, ily := curve.ScalarBaseMult(i[:32])
+	x, y := curve.Add(ilx, ily, ek.x, ek.y)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, ErrInvalidChildKey
+	}
+	return &ExtendedKey{nil, x, y, i[32:], ek.depth + 1, fingerprint, index}, nil
+}
+
+// Derive pathの順に子の鍵を導出する
+func (ek *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	k := ek
+	for _, index := range path {
+		var err error
+		k, err = k.Child(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Neuter 秘密鍵を取り除いた拡張公開鍵
+func (ek *ExtendedKey) Neuter() *ExtendedKey {
+	return &ExtendedKey{nil, ek.x, ek.y, ek.chainCode, ek.depth, ek.parentFingerprint, ek.childNumber}
+}
+
+func (ek *ExtendedKey) PublicKey() *ecdsa.PublicKey {
+	return &ecdsa.PublicKey{Curve: utils.Secp256k1(), X: ek.x, Y: ek.y}
+}
+
+// PrivateKey 公開鍵だけの場合はnil
+func (ek *ExtendedKey) PrivateKey() *ecdsa.PrivateKey {
+	if !ek.IsPrivate() {
+		return nil
+	}
+	return &ecdsa.PrivateKey{PublicKey: *ek.PublicKey(), D: new(big.Int).Set(ek.privateKey)}
+}
+
+// String xprv... または xpub... の形式
+func (ek *ExtendedKey) String() string {
+	buf := make([]byte, 0, 82)
+	if ek.IsPrivate() {
+		buf = append(buf, xprvVersion[:]...)
+	} else {
+		buf = append(buf, xpubVersion[:]...)
+	}
+	buf = append(buf, ek.depth)
+	buf = append(buf, ek.parentFingerprint[:]...)
+	buf = append(buf, uint32Bytes(ek.childNumber)...)
+	buf = append(buf, ek.chainCode...)
+	if ek.IsPrivate() {
+		buf = append(buf, 0x00)
+		buf = append(buf, padScalar(ek.privateKey)...)
+	} else {
+		buf = append(buf, ek.compressedPublicKey()...)
+	}
+	h1 := sha256.Sum256(buf)
+	h2 := sha256.Sum256(h1[:])
+	return base58.Encode(append(buf, h2[:4]...))
+}
+
+func (ek *ExtendedKey) compressedPublicKey() []byte {
+	return elliptic.MarshalCompressed(utils.Secp256k1(), ek.x, ek.y)
+}
+
+// fingerprint 公開鍵のHASH160の先頭4バイト（子の鍵に親として記録する）
+func (ek *ExtendedKey) fingerprint() [4]byte {
+	h := sha256.Sum256(ek.compressedPublicKey())
+	r := ripemd160.New()
+	r.Write(h[:])
+	var fp [4]byte
+	copy(fp[:], r.Sum(nil))
+	return fp
+}
+
+// ParsePath "m/44'/0'/0'/0/0" のようなpathをindexの列にする（'、h、H はhardened）
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %q must start with m", ErrInvalidPath, path)
+	}
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		hardened := strings.HasSuffix(p, "'") || strings.HasSuffix(p, "h") || strings.HasSuffix(p, "H")
+		if hardened {
+			p = p[:len(p)-1]
+		}
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil || n >= HARDENED_KEY_START {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		index := uint32(n)
+		if hardened {
+			index += HARDENED_KEY_START
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+func padScalar(k *big.Int) []byte {
+	b := make([]byte, 32)
+	return k.FillBytes(b)
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
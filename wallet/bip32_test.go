@@ -0,0 +1,91 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// BIP32のTest vector 1と3
+// cf. https://github.com/bitcoin/bips/blob/master/bip-0032.mediawiki#test-vectors
+var bip32Vectors = []struct {
+	name string
+	seed string
+	keys []struct {
+		path string
+		xpub string
+		xprv string
+	}
+}{
+	{
+		name: "vector 1",
+		seed: "000102030405060708090a0b0c0d0e0f",
+		keys: []struct {
+			path string
+			xpub string
+			xprv string
+		}{
+			{
+				path: "m",
+				xpub: "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+				xprv: "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi",
+			},
+			{
+				path: "m/0'",
+				xpub: "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw",
+				xprv: "xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7",
+			},
+			{
+				path: "m/0'/1/2'/2/1000000000",
+				xpub: "xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy",
+				xprv: "xprvA41z7zogVVwxVSgdKUHDy1SKmdb533PjDz7J6N6mV6uS3ze1ai8FHa8kmHScGpWmj4WggLyQjgPie1rFSruoUihUZREPSL39UNdE3BBDu76",
+			},
+		},
+	},
+	{
+		// 秘密鍵の先頭が0のため、0埋めが必要なケース
+		name: "vector 3",
+		seed: "4b381541583be4423346c643850da4b320e46a87ae3d2a4e6da11eba819cd4acba45d239319ac14f863b8d5ab5a0d0c64d2e8a1e7d1457df2e5a3c51c73235be",
+		keys: []struct {
+			path string
+			xpub string
+			xprv string
+		}{
+			{
+				path: "m",
+				xpub: "xpub661MyMwAqRbcEZVB4dScxMAdx6d4nFc9nvyvH3v4gJL378CSRZiYmhRoP7mBy6gSPSCYk6SzXPTf3ND1cZAceL7SfJ1Z3GC8vBgp2epUt13",
+				xprv: "xprv9s21ZrQH143K25QhxbucbDDuQ4naNntJRi4KUfWT7xo4EKsHt2QJDu7KXp1A3u7Bi1j8ph3EGsZ9Xvz9dGuVrtHHs7pXeTzjuxBrCmmhgC6",
+			},
+			{
+				path: "m/0'",
+				xpub: "xpub68NZiKmJWnxxS6aaHmn81bvJeTESw724CRDs6HbuccFQN9Ku14VQrADWgqbhhTHBaohPX4CjNLf9fq9MYo6oDaPPLPxSb7gwQN3ih19Zm4Y",
+				xprv: "xprv9uPDJpEQgRQfDcW7BkF7eTya6RPxXeJCqCJGHuCJ4GiRVLzkTXBAJMu2qaMWPrS7AANYqdq6vcBcBUdJCVVFceUvJFjaPdGZ2y9WACViL4L",
+			},
+		},
+	},
+}
+
+func TestBIP32Vectors(t *testing.T) {
+	for _, v := range bip32Vectors {
+		seed, _ := hex.DecodeString(v.seed)
+		master, err := NewMasterKey(seed)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		for _, k := range v.keys {
+			path, err := ParsePath(k.path)
+			if err != nil {
+				t.Fatalf("%s %s: %v", v.name, k.path, err)
+			}
+			key, err := master.Derive(path)
+			if err != nil {
+				t.Fatalf("%s %s: %v", v.name, k.path, err)
+			}
+			if got := key.String(); got != k.xprv {
+				t.Errorf("%s %s: xprv\n got  %s\n want %s", v.name, k.path, got, k.xprv)
+			}
+			if got := key.Neuter().String(); got != k.xpub {
+				t.Errorf("%s %s: xpub\n got  %s\n want %s", v.name, k.path, got, k.xpub)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// BIP39
+// cf. https://github.com/bitcoin/bips/blob/master/bip-0039.mediawiki
+
+const (
+	MNEMONIC_ENTROPY_BITS = 128 // 12単語
+	mnemonicWordBits      = 11
+	seedIterations        = 2048
+	seedLength            = 64
+)
+
+var (
+	ErrInvalidMnemonic    = errors.New("invalid mnemonic")
+	ErrInvalidEntropy     = errors.New("entropy must be 128-256 bits in 32 bit steps")
+	ErrNonASCIIPassphrase = errors.New("passphrase must be ASCII")
+)
+
+//go:embed wordlists/english.txt
+var englishWordlist string
+
+var (
+	wordlist    = strings.Fields(englishWordlist)
+	wordIndexes = func() map[string]int {
+		m := make(map[string]int, len(wordlist))
+		for i, w := range wordlist {
+			m[w] = i
+		}
+		return m
+	}()
+)
+
+// NewMnemonic bits（128〜256、32の倍数）のランダムなエントロピーからリカバリーフレーズを作る
+func NewMnemonic(bits int) (string, error) {
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", ErrInvalidEntropy
+	}
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return MnemonicFromEntropy(entropy)
+}
+
+// MnemonicFromEntropy
+// エントロピーの後ろにsha256の先頭(ENT/32)ビットをチェックサムとして付け、11ビットずつ単語にする
+func MnemonicFromEntropy(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", ErrInvalidEntropy
+	}
+	h := sha256.Sum256(entropy)
+	data := append(append([]byte{}, entropy...), h[0])
+	n := (bits + bits/32) / mnemonicWordBits
+	words := make([]string, n)
+	for i := range words {
+		words[i] = wordlist[readBits(data, i*mnemonicWordBits, mnemonicWordBits)]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// EntropyFromMnemonic 単語とチェックサムを確認し、エントロピーに戻す
+func EntropyFromMnemonic(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d words", ErrInvalidMnemonic, len(words))
+	}
+	total := len(words) * mnemonicWordBits
+	checksumBits := total / 33
+	entropyBits := total - checksumBits
+
+	data := make([]byte, (total+7)/8)
+	for i, w := range words {
+		index, ok := wordIndexes[w]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, w)
+		}
+		writeBits(data, i*mnemonicWordBits, mnemonicWordBits, index)
+	}
+	entropy := data[:entropyBits/8]
+	h := sha256.Sum256(entropy)
+	if readBits(data, entropyBits, checksumBits) != readBits(h[:], 0, checksumBits) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidMnemonic)
+	}
+	return entropy, nil
+}
+
+// ValidateMnemonic リカバリーフレーズが正しいか
+func ValidateMnemonic(mnemonic string) error {
+	_, err := EntropyFromMnemonic(mnemonic)
+	return err
+}
+
+// SeedFromMnemonic
+// PBKDF2-HMAC-SHA512でリカバリーフレーズとパスフレーズから64バイトのseedを作る
+// NFKDの正規化は行わないので、パスフレーズはASCIIに限る
+func SeedFromMnemonic(mnemonic string, passphrase string) ([]byte, error) {
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(passphrase); i++ {
+		if passphrase[i] >= 0x80 {
+			return nil, ErrNonASCIIPassphrase
+		}
+	}
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), seedIterations, seedLength, sha512.New), nil
+}
+
+// readBits dataの先頭からoffsetビット目からnビットを読む（big endian）
+func readBits(data []byte, offset int, n int) int {
+	v := 0
+	for i := offset; i < offset+n; i++ {
+		v = v<<1 | int(data[i/8]>>(7-uint(i%8))&1)
+	}
+	return v
+}
+
+// writeBits dataの先頭からoffsetビット目にvの下位nビットを書く（big endian）
+func writeBits(data []byte, offset int, n int, v int) {
+	for i := 0; i < n; i++ {
+		if v>>(n-1-i)&1 == 1 {
+			pos := offset + i
+			data[pos/8] |= 1 << (7 - uint(pos%8))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// TestTrezorVector1
+// BIP39の参照実装（python-mnemonic）のvectors.jsonの1つ目（パスフレーズは "TREZOR"）
+func TestTrezorVector1(t *testing.T) {
+	const (
+		entropy  = "00000000000000000000000000000000"
+		mnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+		seed     = "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+		xprv     = "xprv9s21ZrQH143K3h3fDYiay8mocZ3afhfULfb5GX8kCBdno77K4HiA15Tg23wpbeF1pLfs1c5SPmYHrEpTuuRhxMwvKDwqdKiGJS9XFKzUsAF"
+	)
+	e, _ := hex.DecodeString(entropy)
+	m, err := MnemonicFromEntropy(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != mnemonic {
+		t.Errorf("mnemonic\n got  %s\n want %s", m, mnemonic)
+	}
+	got, err := EntropyFromMnemonic(mnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(got) != entropy {
+		t.Errorf("entropy = %x, want %s", got, entropy)
+	}
+
+	s, err := SeedFromMnemonic(mnemonic, "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(s) != seed {
+		t.Errorf("seed\n got  %x\n want %s", s, seed)
+	}
+	master, err := NewMasterKey(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if master.String() != xprv {
+		t.Errorf("xprv\n got  %s\n want %s", master, xprv)
+	}
+}
+
+func TestInvalidMnemonic(t *testing.T) {
+	// 最後の単語でチェックサムが合わない
+	if err := ValidateMnemonic("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"); err == nil {
+		t.Error("mnemonic with a wrong checksum was accepted")
+	}
+}
@@ -0,0 +1,101 @@
+package wallet
+
+import "fmt"
+
+// BIP44
+// m / 44' / coin_type' / account' / change / address_index
+// cf. https://github.com/bitcoin/bips/blob/master/bip-0044.mediawiki
+
+const (
+	BIP44_PURPOSE   = 44
+	BIP44_COIN_TYPE = 0 // アドレスの形式がBitcoinと同じなので、Bitcoinと同じ番号を使う
+	BIP44_EXTERNAL  = 0 // 受け取り用のアドレス
+	BIP44_INTERNAL  = 1 // お釣り用のアドレス
+)
+
+// HDWallet
+// 1つのリカバリーフレーズから、受け取り用のwalletをいくつでも導出できる
+// リカバリーフレーズとパスフレーズがあれば、同じwalletをすべて復元できる
+type HDWallet struct {
+	mnemonic string
+	master   *ExtendedKey
+}
+
+// NewHDWallet 新しいリカバリーフレーズでHDWalletを作る
+func NewHDWallet(passphrase string) (*HDWallet, error) {
+	mnemonic, err := NewMnemonic(MNEMONIC_ENTROPY_BITS)
+	if err != nil {
+		return nil, err
+	}
+	return RestoreHDWallet(mnemonic, passphrase)
+}
+
+// RestoreHDWallet リカバリーフレーズとパスフレーズからHDWalletを復元する
+func RestoreHDWallet(mnemonic string, passphrase string) (*HDWallet, error) {
+	seed, err := SeedFromMnemonic(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	return &HDWallet{mnemonic: mnemonic, master: master}, nil
+}
+
+// Mnemonic リカバリーフレーズ（secretなので表示・保存には注意する）
+func (hd *HDWallet) Mnemonic() string {
+	return hd.mnemonic
+}
+
+func (hd *HDWallet) MasterKey() *ExtendedKey {
+	return hd.master
+}
+
+// AccountKey m/44'/0'/account' の拡張鍵
+// accountはHARDENED_KEY_STARTを足す前の番号なので、HARDENED_KEY_START以上の場合はErrInvalidPath
+func (hd *HDWallet) AccountKey(account uint32) (*ExtendedKey, error) {
+	if account >= HARDENED_KEY_START {
+		return nil, fmt.Errorf("%w: account %d", ErrInvalidPath, account)
+	}
+	return hd.master.Derive([]uint32{
+		HARDENED_KEY_START + BIP44_PURPOSE,
+		HARDENED_KEY_START + BIP44_COIN_TYPE,
+		HARDENED_KEY_START + account,
+	})
+}
+
+// Wallet m/44'/0'/account'/change/index の鍵のwallet
+// changeとindexはhardenedでないので、HARDENED_KEY_START以上の場合はErrInvalidPath
+func (hd *HDWallet) Wallet(account uint32, change uint32, index uint32) (*Wallet, error) {
+	if change >= HARDENED_KEY_START || index >= HARDENED_KEY_START {
+		return nil, fmt.Errorf("%w: change %d, index %d", ErrInvalidPath, change, index)
+	}
+	accountKey, err := hd.AccountKey(account)
+	if err != nil {
+		return nil, err
+	}
+	k, err := accountKey.Derive([]uint32{change, index})
+	if err != nil {
+		return nil, err
+	}
+	return NewWalletFromPrivateKey(k.PrivateKey()), nil
+}
+
+// ReceiveWallet 最初のaccountの、index番目の受け取り用のwallet
+func (hd *HDWallet) ReceiveWallet(index uint32) (*Wallet, error) {
+	return hd.Wallet(0, BIP44_EXTERNAL, index)
+}
+
+// ReceiveAddresses 最初のaccountの、受け取り用のアドレスをn個
+func (hd *HDWallet) ReceiveAddresses(n uint32) ([]string, error) {
+	addresses := make([]string, 0, n)
+	for i := uint32(0); i < n; i++ {
+		w, err := hd.ReceiveWallet(i)
+		if err != nil {
+			return nil, fmt.Errorf("index %d: %w", i, err)
+		}
+		addresses = append(addresses, w.BlockchainAddress())
+	}
+	return addresses, nil
+}
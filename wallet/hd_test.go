@@ -0,0 +1,45 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestBIP44Address
+// よく知られたテスト用のリカバリーフレーズの m/44'/0'/0'/0/0 のアドレス（Bitcoinのwalletと同じ）
+func TestBIP44Address(t *testing.T) {
+	const mnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	hd, err := RestoreHDWallet(mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := hd.ReceiveWallet(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.BlockchainAddress(), "1LqBGSKuX5yYUonjxT5qGfpUsXKYYWeabA"; got != want {
+		t.Errorf("address = %s, want %s", got, want)
+	}
+}
+
+func TestHDWalletPathRange(t *testing.T) {
+	hd, err := NewHDWallet("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hd.AccountKey(HARDENED_KEY_START); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("AccountKey(2^31): err = %v, want ErrInvalidPath", err)
+	}
+	for _, p := range [][3]uint32{
+		{HARDENED_KEY_START, 0, 0},
+		{0, HARDENED_KEY_START, 0},
+		{0, 0, HARDENED_KEY_START},
+	} {
+		if _, err := hd.Wallet(p[0], p[1], p[2]); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Wallet(%d, %d, %d): err = %v, want ErrInvalidPath", p[0], p[1], p[2], err)
+		}
+	}
+	if _, err := hd.Wallet(HARDENED_KEY_START-1, BIP44_INTERNAL, HARDENED_KEY_START-1); err != nil {
+		t.Errorf("Wallet(2^31-1, 1, 2^31-1): %v", err)
+	}
+}
@@ -0,0 +1,385 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_blockchain/utils"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// 暗号化した秘密鍵のファイル（EthereumのJSON keystoreと同じく、scryptで鍵を作りAES-GCMで暗号化する）
+const (
+	KEYSTORE_VERSION      = 1
+	KEYSTORE_SCRYPT_N     = 1 << 18
+	KEYSTORE_SCRYPT_MIN_N = 1 << 14 // 読み込むファイルのNの範囲（ファイルの値でメモリやCPUを使い尽くさないようにする）
+	KEYSTORE_SCRYPT_MAX_N = 1 << 20
+	KEYSTORE_SCRYPT_R     = 8
+	KEYSTORE_SCRYPT_P     = 1
+	keystoreKeyLength     = 32 // AES-256
+	keystoreSaltLength    = 32
+	keystoreFileSuffix    = ".json"
+)
+
+var (
+	ErrWrongPassword  = errors.New("wrong password or corrupted keystore")
+	ErrEmptyPassword  = errors.New("password must not be empty")
+	ErrWalletNotFound = errors.New("wallet not found in keystore")
+	ErrWalletLocked   = errors.New("wallet is locked")
+	ErrWalletExists   = errors.New("wallet already exists in keystore")
+)
+
+// encryptedKey keystoreのファイルの形式
+type encryptedKey struct {
+	Version           int            `json:"version"`
+	BlockchainAddress string         `json:"blockchain_address"`
+	Curve             string         `json:"curve"`
+	Crypto            encryptedCrypt `json:"crypto"`
+}
+
+type encryptedCrypt struct {
+	Cipher       string          `json:"cipher"`
+	CipherText   string          `json:"ciphertext"`
+	CipherParams gcmParams       `json:"cipherparams"`
+	KDF          string          `json:"kdf"`
+	KDFParams    scryptKDFParams `json:"kdfparams"`
+}
+
+type gcmParams struct {
+	Nonce string `json:"nonce"`
+}
+
+type scryptKDFParams struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+// EncryptWallet
+// walletの秘密鍵をpasswordで暗号化したJSONを返す
+// アドレスをAES-GCMの追加データにして、別のアドレスのファイルにすり替えられないようにする
+func EncryptWallet(w *Wallet, password string) ([]byte, error) {
+	return encryptWallet(w, password, KEYSTORE_SCRYPT_N)
+}
+
+// encryptWallet scryptのNを指定して暗号化する（テストではKEYSTORE_SCRYPT_MIN_Nで時間を短くする）
+func encryptWallet(w *Wallet, password string, n int) ([]byte, error) {
+	if password == "" {
+		return nil, ErrEmptyPassword
+	}
+	salt := make([]byte, keystoreSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	params := scryptKDFParams{
+		N:     n,
+		R:     KEYSTORE_SCRYPT_R,
+		P:     KEYSTORE_SCRYPT_P,
+		DKLen: keystoreKeyLength,
+		Salt:  hex.EncodeToString(salt),
+	}
+	aead, err := keystoreCipher(password, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	plain := w.privateKey.D.FillBytes(make([]byte, utils.SCALAR_HEX_LENGTH/2))
+	ciphertext := aead.Seal(nil, nonce, plain, []byte(w.blockchainAddress))
+
+	return json.MarshalIndent(encryptedKey{
+		Version:           KEYSTORE_VERSION,
+		BlockchainAddress: w.blockchainAddress,
+		Curve:             w.privateKey.Curve.Params().Name,
+		Crypto: encryptedCrypt{
+			Cipher:       "aes-256-gcm",
+			CipherText:   hex.EncodeToString(ciphertext),
+			CipherParams: gcmParams{Nonce: hex.EncodeToString(nonce)},
+			KDF:          "scrypt",
+			KDFParams:    params,
+		},
+	}, "", "  ")
+}
+
+// DecryptWallet EncryptWalletのJSONをpasswordで復号してwalletに戻す
+func DecryptWallet(data []byte, password string) (*Wallet, error) {
+	var k encryptedKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, err
+	}
+	if k.Version != KEYSTORE_VERSION || k.Crypto.Cipher != "aes-256-gcm" || k.Crypto.KDF != "scrypt" {
+		return nil, fmt.Errorf("keystore: unsupported format (version=%d, cipher=%s, kdf=%s)",
+			k.Version, k.Crypto.Cipher, k.Crypto.KDF)
+	}
+	curve, err := utils.CurveByName(k.Curve)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(k.Crypto.KDFParams.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("keystore: invalid salt: %v", err)
+	}
+	nonce, err := hex.DecodeString(k.Crypto.CipherParams.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("keystore: invalid nonce: %v", err)
+	}
+	ciphertext, err := hex.DecodeString(k.Crypto.CipherText)
+	if err != nil {
+		return nil, fmt.Errorf("keystore: invalid ciphertext: %v", err)
+	}
+	aead, err := keystoreCipher(password, salt, k.Crypto.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("keystore: invalid nonce length %d", len(nonce))
+	}
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(k.BlockchainAddress))
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+
+	// 復号できても、秘密鍵として使える 1 <= d < N の32バイトの値でなければ受け付けない
+	if len(plain) != utils.SCALAR_HEX_LENGTH/2 {
+		return nil, fmt.Errorf("keystore: invalid private key length %d", len(plain))
+	}
+	d := new(big.Int).SetBytes(plain)
+	if d.Sign() <= 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("keystore: private key is out of range")
+	}
+	privateKey := &ecdsa.PrivateKey{D: d}
+	privateKey.PublicKey.Curve = curve
+	privateKey.PublicKey.X, privateKey.PublicKey.Y = curve.ScalarBaseMult(plain)
+	w := NewWalletFromPrivateKey(privateKey)
+	if w.blockchainAddress != k.BlockchainAddress {
+		return nil, fmt.Errorf("keystore: key does not match address %s", k.BlockchainAddress)
+	}
+	return w, nil
+}
+
+func keystoreCipher(password string, salt []byte, params scryptKDFParams) (cipher.AEAD, error) {
+	if params.DKLen != keystoreKeyLength {
+		return nil, fmt.Errorf("keystore: unsupported dklen %d", params.DKLen)
+	}
+	// scrypt.Keyを呼ぶ前に確かめる（Nが大きいと 128*r*N バイトのメモリを使う）
+	n := params.N
+	if n < KEYSTORE_SCRYPT_MIN_N || n > KEYSTORE_SCRYPT_MAX_N || n&(n-1) != 0 {
+		return nil, fmt.Errorf("keystore: unsupported scrypt n %d (power of 2 from %d to %d)",
+			n, KEYSTORE_SCRYPT_MIN_N, KEYSTORE_SCRYPT_MAX_N)
+	}
+	if params.R != KEYSTORE_SCRYPT_R || params.P != KEYSTORE_SCRYPT_P {
+		return nil, fmt.Errorf("keystore: unsupported scrypt r=%d, p=%d", params.R, params.P)
+	}
+	key, err := scrypt.Key([]byte(password), salt, params.N, params.R, params.P, params.DKLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Keystore
+// ディレクトリに「アドレス.json」として暗号化した秘密鍵を保存する
+// Unlockしたwalletだけをメモリ上に持ち、Lockで取り除く
+type Keystore struct {
+	dir      string
+	mux      sync.Mutex
+	unlocked map[string]*Wallet
+}
+
+func NewKeystore(dir string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Keystore{dir: dir, unlocked: make(map[string]*Wallet)}, nil
+}
+
+func (ks *Keystore) Dir() string {
+	return ks.dir
+}
+
+// Create 新しいwalletを作成して保存する。作成したwalletはUnlockした状態になる
+func (ks *Keystore) Create(password string, curve elliptic.Curve) (*Wallet, error) {
+	w := NewWalletWithCurve(curve)
+	if err := ks.Import(w, password); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Import 既存のwalletを暗号化して保存する。保存したwalletはUnlockした状態になる
+func (ks *Keystore) Import(w *Wallet, password string) error {
+	data, err := EncryptWallet(w, password)
+	if err != nil {
+		return err
+	}
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	if err := ks.save(w.blockchainAddress, data); err != nil {
+		return err
+	}
+	ks.unlocked[w.blockchainAddress] = w
+	return nil
+}
+
+// Export 暗号化したままのJSONを返す（別のkeystoreにImportFileできる）
+func (ks *Keystore) Export(blockchainAddress string) ([]byte, error) {
+	path, err := ks.path(blockchainAddress)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrWalletNotFound, blockchainAddress)
+	}
+	return data, err
+}
+
+// ImportFile Exportした暗号化済みのJSONをpasswordで確認してから保存する
+func (ks *Keystore) ImportFile(data []byte, password string) (*Wallet, error) {
+	w, err := DecryptWallet(data, password)
+	if err != nil {
+		return nil, err
+	}
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	if err := ks.save(w.blockchainAddress, data); err != nil {
+		return nil, err
+	}
+	ks.unlocked[w.blockchainAddress] = w
+	return w, nil
+}
+
+// Unlock passwordで復号して、Lockするまでメモリ上に持つ
+func (ks *Keystore) Unlock(blockchainAddress string, password string) (*Wallet, error) {
+	data, err := ks.Export(blockchainAddress)
+	if err != nil {
+		return nil, err
+	}
+	w, err := DecryptWallet(data, password)
+	if err != nil {
+		return nil, err
+	}
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	ks.unlocked[blockchainAddress] = w
+	return w, nil
+}
+
+// Lock 復号したwalletをメモリ上から取り除く
+func (ks *Keystore) Lock(blockchainAddress string) {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	delete(ks.unlocked, blockchainAddress)
+}
+
+// Wallet Unlockしたwallet。Lockされている場合はErrWalletLocked
+func (ks *Keystore) Wallet(blockchainAddress string) (*Wallet, error) {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	if w, ok := ks.unlocked[blockchainAddress]; ok {
+		return w, nil
+	}
+	path, err := ks.path(blockchainAddress)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrWalletNotFound, blockchainAddress)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrWalletLocked, blockchainAddress)
+}
+
+func (ks *Keystore) Unlocked(blockchainAddress string) bool {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	_, ok := ks.unlocked[blockchainAddress]
+	return ok
+}
+
+// Addresses 保存されているwalletのアドレス
+func (ks *Keystore) Addresses() ([]string, error) {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, keystoreFileSuffix) {
+			continue
+		}
+		address := strings.TrimSuffix(name, keystoreFileSuffix)
+		if utils.ValidateAddress(address) != nil {
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// path アドレスのファイル。正しいアドレスに限ることで、ディレクトリの外を指さないようにする
+func (ks *Keystore) path(blockchainAddress string) (string, error) {
+	if err := utils.ValidateAddress(blockchainAddress); err != nil {
+		return "", err
+	}
+	return filepath.Join(ks.dir, blockchainAddress+keystoreFileSuffix), nil
+}
+
+// save まだ保存されていないアドレスのファイルとして書き込む（ロックを取得してから呼ぶ）
+func (ks *Keystore) save(blockchainAddress string, data []byte) error {
+	path, err := ks.path(blockchainAddress)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%w: %s", ErrWalletExists, blockchainAddress)
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic 一時ファイルに書いてからrenameする（書き込み途中のファイルを残さない）
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
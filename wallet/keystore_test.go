@@ -0,0 +1,59 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"go_blockchain/utils"
+	"testing"
+)
+
+func TestEncryptDecryptWallet(t *testing.T) {
+	for _, w := range []*Wallet{NewWallet(), NewWalletWithCurve(utils.Secp256k1())} {
+		data, err := encryptWallet(w, "correct horse", KEYSTORE_SCRYPT_MIN_N)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecryptWallet(data, "correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.BlockchainAddress() != w.BlockchainAddress() || got.PrivateKeyStr() != w.PrivateKeyStr() {
+			t.Errorf("decrypted wallet %s does not match %s", got.BlockchainAddress(), w.BlockchainAddress())
+		}
+	}
+}
+
+func TestDecryptWalletWrongPassword(t *testing.T) {
+	data, err := encryptWallet(NewWallet(), "correct horse", KEYSTORE_SCRYPT_MIN_N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptWallet(data, "battery staple"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("err = %v, want ErrWrongPassword", err)
+	}
+}
+
+// TestDecryptWalletScryptParams ファイルのscryptのパラメーターが範囲外の場合は、鍵を計算せずに受け付けない
+func TestDecryptWalletScryptParams(t *testing.T) {
+	data, err := encryptWallet(NewWallet(), "correct horse", KEYSTORE_SCRYPT_MIN_N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []struct{ n, r, p int }{
+		{KEYSTORE_SCRYPT_MAX_N * 2, KEYSTORE_SCRYPT_R, KEYSTORE_SCRYPT_P},
+		{KEYSTORE_SCRYPT_MIN_N / 2, KEYSTORE_SCRYPT_R, KEYSTORE_SCRYPT_P},
+		{KEYSTORE_SCRYPT_MIN_N + 1, KEYSTORE_SCRYPT_R, KEYSTORE_SCRYPT_P},
+		{KEYSTORE_SCRYPT_MIN_N, 1 << 20, KEYSTORE_SCRYPT_P},
+		{KEYSTORE_SCRYPT_MIN_N, KEYSTORE_SCRYPT_R, 1 << 20},
+	} {
+		var k encryptedKey
+		if err := json.Unmarshal(data, &k); err != nil {
+			t.Fatal(err)
+		}
+		k.Crypto.KDFParams.N, k.Crypto.KDFParams.R, k.Crypto.KDFParams.P = p.n, p.r, p.p
+		modified, _ := json.Marshal(k)
+		if _, err := DecryptWallet(modified, "correct horse"); err == nil || errors.Is(err, ErrWrongPassword) {
+			t.Errorf("n=%d, r=%d, p=%d: err = %v, want unsupported scrypt parameters", p.n, p.r, p.p, err)
+		}
+	}
+}
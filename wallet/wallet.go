@@ -4,13 +4,8 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/json"
-	"fmt"
 	"go_blockchain/utils"
-
-	"github.com/btcsuite/btcutil/base58"
-	"golang.org/x/crypto/ripemd160"
 )
 
 type Wallet struct {
@@ -23,41 +18,23 @@ type Wallet struct {
 // まず privateKey, publicKeyを生成
 // 次に publicKeyから決まった手順でblockchainAddressを作成
 func NewWallet() *Wallet {
+	return NewWalletWithCurve(elliptic.P256())
+}
+
+// NewWalletWithCurve curveの鍵でwalletを作成する（elliptic.P256() または utils.Secp256k1()）
+func NewWalletWithCurve(curve elliptic.Curve) *Wallet {
 	// 1. Creating ECDSA private key (32 bytes) public key (64 bytes)
+	privateKey, _ := ecdsa.GenerateKey(curve, rand.Reader)
+	return NewWalletFromPrivateKey(privateKey)
+}
+
+// NewWalletFromPrivateKey 既存の秘密鍵からwalletを作成する（HDWalletで導出した鍵など）
+func NewWalletFromPrivateKey(privateKey *ecdsa.PrivateKey) *Wallet {
 	w := new(Wallet)
-	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	w.privateKey = privateKey
 	w.publicKey = &w.privateKey.PublicKey
-	// 2. Perform SHA-256 hashing on the public key (32 bytes).
-	h2 := sha256.New()
-	h2.Write(w.publicKey.X.Bytes())
-	h2.Write(w.publicKey.Y.Bytes())
-	digest2 := h2.Sum(nil)
-	// 3. Perform RIPEMD-160 hashing on the result of SHA-256 (20 bytes).
-	h3 := ripemd160.New()
-	h3.Write(digest2)
-	digest3 := h3.Sum(nil)
-	// 4. Add version byte in front of RIPEMD-160 hash (0x00 for Main Network).
-	vd4 := make([]byte, 21)
-	vd4[0] = 0x00
-	copy(vd4[1:], digest3[:])
-	// 5. Perform SHA-256 hash on the extended RIPEMD-160 result.
-	h5 := sha256.New()
-	h5.Write(vd4)
-	digest5 := h5.Sum(nil)
-	// 6. Perform SHA-256 hash on the result of the previous SHA-256 hash.
-	h6 := sha256.New()
-	h6.Write(digest5)
-	digest6 := h6.Sum(nil)
-	// 7. Take the first 4 bytes of the second SHA-256 hash for checksum.
-	chsum := digest6[:4]
-	// 8. Add the 4 checksum bytes from 7 at the end of extended RIPEMD-160 hash from 4 (25 bytes).
-	dc8 := make([]byte, 25)
-	copy(dc8[:21], vd4[:])
-	copy(dc8[21:], chsum[:])
-	// 9. Convert the result from a byte string into base58.
-	address := base58.Encode(dc8)
-	w.blockchainAddress = address
+	// 2-9. publicKeyからblockchainAddressを作成
+	w.blockchainAddress = utils.AddressFromPublicKey(w.publicKey)
 	return w
 }
 
@@ -66,7 +43,7 @@ func (w *Wallet) PrivateKey() *ecdsa.PrivateKey {
 }
 
 func (w *Wallet) PrivateKeyStr() string {
-	return fmt.Sprintf("%x", w.privateKey.D.Bytes())
+	return utils.PrivateKeyString(w.privateKey)
 }
 
 func (w *Wallet) PublicKey() *ecdsa.PublicKey {
@@ -74,20 +51,24 @@ func (w *Wallet) PublicKey() *ecdsa.PublicKey {
 }
 
 func (w *Wallet) PublicKeyStr() string {
-	return fmt.Sprintf("%064x%064x", w.publicKey.X.Bytes(), w.publicKey.Y.Bytes())
+	return utils.PublicKeyString(w.publicKey)
+}
+
+// PublicKeyCompressedStr SEC1の圧縮形式の公開鍵
+func (w *Wallet) PublicKeyCompressedStr() string {
+	return utils.PublicKeyCompressedString(w.publicKey)
 }
 
 func (w *Wallet) BlockchainAddress() string {
 	return w.blockchainAddress
 }
 
+// MarshalJSON 秘密鍵は含めない（秘密鍵はKeystoreで暗号化して保存する）
 func (w *Wallet) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		PrivateKey        string `json:"private_key"`
 		PublicKey         string `json:"public_key"`
 		BlockchainAddress string `json:"blockchain_address"`
 	}{
-		PrivateKey:        w.PrivateKeyStr(),
 		PublicKey:         w.PublicKeyStr(),
 		BlockchainAddress: w.BlockchainAddress(),
 	})
@@ -98,50 +79,59 @@ type Transaction struct {
 	senderPublicKey            *ecdsa.PublicKey
 	senderBlockchainAddress    string
 	recipientBlockchainAddress string
-	value                      float32
+	value                      utils.Amount
+	nonce                      uint64 // ブロックチェーンサーバーから取得した、送信者の次のnonce
 }
 
 func NewTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey,
-	sender string, recipient string, value float32) *Transaction {
-	return &Transaction{privateKey, publicKey, sender, recipient, value}
+	sender string, recipient string, value utils.Amount, nonce uint64) *Transaction {
+	return &Transaction{privateKey, publicKey, sender, recipient, value, nonce}
 }
 
 // GenerateSignature トランザクションの署名を生成
-// cf. https://pkg.go.dev/crypto/ecdsa#example-package
+// ブロックチェーンサーバーはsが位数の半分以下の署名だけを受け付けるので、utils.Signでそちらに揃える
 func (t *Transaction) GenerateSignature() *utils.Signature {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
-	r, s, _ := ecdsa.Sign(rand.Reader, t.senderPrivateKey, h[:])
-	return &utils.Signature{R: r, S: s}
+	h := utils.TransactionSigningHash(utils.CHAIN_ID,
+		t.senderBlockchainAddress, t.recipientBlockchainAddress, t.value, t.nonce)
+	signature, _ := utils.Sign(t.senderPrivateKey, h[:])
+	return signature
 }
 
 func (t *Transaction) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Sender    string  `json:"sender_blockchain_address"`
-		Recipient string  `json:"recipient_blockchain_address"`
-		Value     float32 `json:"value"`
+		Sender    string       `json:"sender_blockchain_address"`
+		Recipient string       `json:"recipient_blockchain_address"`
+		Value     utils.Amount `json:"value"`
+		Nonce     uint64       `json:"nonce"`
 	}{
 		Sender:    t.senderBlockchainAddress,
 		Recipient: t.recipientBlockchainAddress,
 		Value:     t.value,
+		Nonce:     t.nonce,
 	})
 }
 
+// TransactionRequest
+// 秘密鍵は受け取らない。署名はwalletサーバーがKeystoreでunlockしたwalletの鍵で行う
 type TransactionRequest struct {
-	SenderPrivateKey           *string `json:"sender_private_key"`
 	SenderBlockchainAddress    *string `json:"sender_blockchain_address"`
 	RecipientBlockchainAddress *string `json:"recipient_blockchain_address"`
-	SenderPublicKey            *string `json:"sender_public_key"`
 	Value                      *string `json:"value"`
 }
 
 func (tr *TransactionRequest) Validate() bool {
-	if tr.SenderPrivateKey == nil ||
-		tr.SenderBlockchainAddress == nil ||
+	if tr.SenderBlockchainAddress == nil ||
 		tr.RecipientBlockchainAddress == nil ||
-		tr.SenderPublicKey == nil ||
 		tr.Value == nil {
 		return false
 	}
+	if utils.ValidateAddress(*tr.SenderBlockchainAddress) != nil ||
+		utils.ValidateAddress(*tr.RecipientBlockchainAddress) != nil {
+		return false
+	}
+	// 0以下・NaN・オーバーフローする金額は受け付けない
+	if v, err := utils.ParseAmount(*tr.Value); err != nil || v <= 0 {
+		return false
+	}
 	return true
 }
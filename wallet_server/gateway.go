@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"go_blockchain/client"
+	"go_blockchain/utils"
+	"net/http"
+)
+
+// gatewayError
+// ブロックチェーンサーバー（gateway）のエラーを、ブラウザに返すHTTPのエラーにする
+//   - gatewayが返したエラー（4xx）は、statusとcodeとreasonをそのまま使う
+//   - 繋がらない・5xxの場合は502、タイムアウトの場合は504
+func gatewayError(err error) error {
+	var ae *client.APIError
+	if errors.As(err, &ae) {
+		if ae.StatusCode >= 500 {
+			return utils.NewHTTPError(http.StatusBadGateway, utils.ERROR_BAD_GATEWAY, ae.Error())
+		}
+		code := ae.Code
+		if code == "" {
+			code = utils.ERROR_BAD_GATEWAY
+		}
+		return utils.NewHTTPError(ae.StatusCode, code, ae.Reason)
+	}
+	var te *client.TransportError
+	if errors.As(err, &te) && te.Timeout() {
+		return utils.NewHTTPError(http.StatusGatewayTimeout, utils.ERROR_GATEWAY_TIMEOUT, err.Error())
+	}
+	return utils.NewHTTPError(http.StatusBadGateway, utils.ERROR_BAD_GATEWAY, err.Error())
+}
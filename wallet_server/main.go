@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"go_blockchain/utils"
+	"go_blockchain/wallet"
 	"log"
 )
 
@@ -12,8 +14,21 @@ func init() {
 func main() {
 	port := flag.Uint("port", 8080, "TCP Port Number for Wallet Server")
 	gateway := flag.String("gateway", "http://127.0.0.1:5001", "Blockchain Gateway")
+	curveName := flag.String("curve", utils.CURVE_P256, "Curve of new wallet keys ("+utils.CURVE_P256+" or "+utils.CURVE_SECP256K1+")")
+	keystoreDir := flag.String("keystore", "keystore", "Directory of encrypted wallet keystore files")
 	flag.Parse()
 
-	app := NewWalletServer(uint16(*port), *gateway)
+	curve, err := utils.CurveByName(*curveName)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+
+	keystore, err := wallet.NewKeystore(*keystoreDir)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+
+	app := NewWalletServer(uint16(*port), *gateway, keystore)
+	app.SetCurve(curve)
 	app.Run()
 }
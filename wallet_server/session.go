@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SESSION_TTL          = 15 * time.Minute // 最後に使われてからこの時間が経つとセッションは無効になる
+	SESSION_SWEEP        = time.Minute      // 期限切れのセッションを確認する間隔
+	sessionTokenBytes    = 32
+	sessionAuthScheme    = "Bearer "
+	sessionAuthorization = "Authorization"
+)
+
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+type session struct {
+	blockchainAddress string
+	expiresAt         time.Time
+}
+
+// sessionStore
+// unlockしたwalletを使うためのトークン。秘密鍵の代わりにこのトークンをクライアントが持つ
+// アドレスのセッションがすべて無効になったら、onExpireでwalletをlockする
+type sessionStore struct {
+	mux      sync.Mutex
+	sessions map[string]*session
+	ttl      time.Duration
+	onExpire func(blockchainAddress string)
+}
+
+func newSessionStore(ttl time.Duration, onExpire func(blockchainAddress string)) *sessionStore {
+	return &sessionStore{sessions: make(map[string]*session), ttl: ttl, onExpire: onExpire}
+}
+
+// Create blockchainAddressのwalletを使うための新しいトークン
+func (ss *sessionStore) Create(blockchainAddress string) (string, time.Time, error) {
+	b := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(b)
+	expiresAt := time.Now().Add(ss.ttl)
+
+	ss.mux.Lock()
+	ss.sessions[token] = &session{blockchainAddress, expiresAt}
+	expired := ss.purge(time.Now())
+	ss.mux.Unlock()
+
+	ss.expire(expired)
+	return token, expiresAt, nil
+}
+
+// Address トークンのアドレス。使われるたびに有効期限を延ばす
+func (ss *sessionStore) Address(token string) (string, error) {
+	now := time.Now()
+	ss.mux.Lock()
+	expired := ss.purge(now)
+	s, ok := ss.sessions[token]
+	if ok {
+		s.expiresAt = now.Add(ss.ttl)
+	}
+	ss.mux.Unlock()
+
+	ss.expire(expired)
+	if !ok {
+		return "", ErrInvalidSession
+	}
+	return s.blockchainAddress, nil
+}
+
+// Sweep 期限切れのセッションを削除し、セッションが残っていないwalletをlockする
+func (ss *sessionStore) Sweep() {
+	ss.mux.Lock()
+	expired := ss.purge(time.Now())
+	ss.mux.Unlock()
+	ss.expire(expired)
+}
+
+// Revoke アドレスのセッションをすべて無効にする
+func (ss *sessionStore) Revoke(blockchainAddress string) {
+	ss.mux.Lock()
+	defer ss.mux.Unlock()
+	for token, s := range ss.sessions {
+		if s.blockchainAddress == blockchainAddress {
+			delete(ss.sessions, token)
+		}
+	}
+}
+
+// purge 期限切れのセッションを削除し、セッションが残っていないアドレスを返す（ロックを取得してから呼ぶ）
+func (ss *sessionStore) purge(now time.Time) []string {
+	candidates := make(map[string]bool)
+	for token, s := range ss.sessions {
+		if now.After(s.expiresAt) {
+			delete(ss.sessions, token)
+			candidates[s.blockchainAddress] = true
+		}
+	}
+	for _, s := range ss.sessions {
+		delete(candidates, s.blockchainAddress)
+	}
+	expired := make([]string, 0, len(candidates))
+	for a := range candidates {
+		expired = append(expired, a)
+	}
+	return expired
+}
+
+func (ss *sessionStore) expire(addresses []string) {
+	if ss.onExpire == nil {
+		return
+	}
+	for _, a := range addresses {
+		ss.onExpire(a)
+	}
+}
+
+// sessionToken "Authorization: Bearer <token>" ヘッダーのトークン
+func sessionToken(req *http.Request) string {
+	h := req.Header.Get(sessionAuthorization)
+	if !strings.HasPrefix(h, sessionAuthScheme) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(h, sessionAuthScheme))
+}
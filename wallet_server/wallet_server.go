@@ -1,28 +1,64 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/elliptic"
 	"encoding/json"
-	"go_blockchain/block"
+	"errors"
+	"fmt"
+	"go_blockchain/client"
 	"go_blockchain/utils"
 	"go_blockchain/wallet"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 	"path"
 	"strconv"
+	"time"
 )
 
-const tempDir = "templates"
+const (
+	tempDir = "templates"
+
+	HEALTH_TIMEOUT = 2 * time.Second // /health でgatewayの応答を待つ時間
+
+	MAX_REQUEST_BODY_BYTES = 16 << 10 // /wallet, /wallet/unlock, /transaction のbodyの上限
+
+	// keystoreのscrypt（N = 1<<18）は1回で約256MiBのメモリを使うので、
+	// /wallet と /wallet/unlock で同時に鍵を計算する数を制限する
+	MAX_CONCURRENT_KDF = 2
+	KDF_WAIT_TIMEOUT   = 5 * time.Second // 空きを待つ時間。過ぎたら503とRetry-Afterを返す
+)
 
 type WalletServer struct {
-	port    uint16
-	gateway string // ブロックチェーンサーバーのgatewayとなるアドレス
+	port     uint16
+	gateway  string           // ブロックチェーンサーバーのgatewayとなるアドレス
+	client   *client.Client   // gatewayへのリクエスト（タイムアウトとやり直し）
+	health   *client.Client   // /health 用（やり直さない）
+	curve    elliptic.Curve   // 新しく作るwalletの鍵の曲線
+	keystore *wallet.Keystore // walletの秘密鍵を暗号化して保存する
+	sessions *sessionStore    // unlockしたwalletを使うためのセッション
+	kdf      chan struct{}    // scryptを計算中のリクエスト（MAX_CONCURRENT_KDFまで）
+}
+
+func NewWalletServer(port uint16, gateway string, keystore *wallet.Keystore) *WalletServer {
+	// セッションが切れたwalletはlockする
+	sessions := newSessionStore(SESSION_TTL, keystore.Lock)
+	health := client.NewClient(gateway)
+	health.SetRetry(0, 0)
+	return &WalletServer{port, gateway, client.NewClient(gateway), health, elliptic.P256(), keystore, sessions,
+		make(chan struct{}, MAX_CONCURRENT_KDF)}
 }
 
-func NewWalletServer(port uint16, gateway string) *WalletServer {
-	return &WalletServer{port, gateway}
+// keystoreRequest /wallet, /wallet/unlock のリクエスト
+type keystoreRequest struct {
+	BlockchainAddress *string `json:"blockchain_address"`
+	Password          *string `json:"password"`
+}
+
+// SetCurve 新しく作るwalletの鍵の曲線
+func (ws *WalletServer) SetCurve(curve elliptic.Curve) {
+	ws.curve = curve
 }
 
 func (ws *WalletServer) Port() uint16 {
@@ -39,86 +75,311 @@ func (ws *WalletServer) Index(w http.ResponseWriter, req *http.Request) {
 		t, _ := template.ParseFiles(path.Join(tempDir, "index.html"))
 		t.Execute(w, "")
 	default:
-		log.Printf("ERROR: Invalid HTTP Method")
+		utils.MethodNotAllowed(w, req, http.MethodGet)
 	}
 }
 
+// Wallet
+// GET: keystoreに保存されているwalletの一覧
+// POST: passwordで暗号化したwalletを新しく作成する（秘密鍵は返さない）
 func (ws *WalletServer) Wallet(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
+	case http.MethodGet:
+		addresses, err := ws.keystore.Addresses()
+		if err != nil {
+			utils.WriteHTTPError(w, err)
+			return
+		}
+		type walletStatus struct {
+			BlockchainAddress string `json:"blockchain_address"`
+			Unlocked          bool   `json:"unlocked"`
+		}
+		wallets := make([]walletStatus, len(addresses))
+		for i, a := range addresses {
+			wallets[i] = walletStatus{a, ws.keystore.Unlocked(a)}
+		}
+		m, _ := json.Marshal(struct {
+			Wallets []walletStatus `json:"wallets"`
+		}{wallets})
+		utils.WriteJson(w, http.StatusOK, m)
 	case http.MethodPost:
-		w.Header().Add("Content-Type", "application/json")
-		myWallet := wallet.NewWallet()
-		m, _ := myWallet.MarshalJSON()
-		io.WriteString(w, string(m[:]))
+		var r keystoreRequest
+		if err := utils.DecodeJsonBody(w, req, &r, MAX_REQUEST_BODY_BYTES); err != nil {
+			utils.WriteHTTPError(w, err)
+			return
+		}
+		if r.Password == nil {
+			utils.WriteError(w, http.StatusUnprocessableEntity, utils.ERROR_INVALID_FIELD, "missing password")
+			return
+		}
+		release, ok := ws.acquireKDF(w, req)
+		if !ok {
+			return
+		}
+		myWallet, err := ws.keystore.Create(*r.Password, ws.curve)
+		release()
+		if err != nil {
+			utils.WriteHTTPError(w, keystoreError(err))
+			return
+		}
+		ws.writeSession(w, http.StatusCreated, myWallet)
 	default:
-		w.WriteHeader(http.StatusBadRequest)
-		log.Println("ERROR: Invalid HTTP Method")
+		utils.MethodNotAllowed(w, req, http.MethodGet, http.MethodPost)
 	}
 }
 
+// writeSession walletの新しいセッションを作成し、公開鍵・アドレスと一緒に返す
+func (ws *WalletServer) writeSession(w http.ResponseWriter, status int, myWallet *wallet.Wallet) {
+	token, expiresAt, err := ws.sessions.Create(myWallet.BlockchainAddress())
+	if err != nil {
+		utils.WriteHTTPError(w, err)
+		return
+	}
+	m, _ := json.Marshal(struct {
+		PublicKey         string    `json:"public_key"`
+		BlockchainAddress string    `json:"blockchain_address"`
+		SessionToken      string    `json:"session_token"`
+		ExpiresAt         time.Time `json:"expires_at"`
+	}{
+		PublicKey:         myWallet.PublicKeyStr(),
+		BlockchainAddress: myWallet.BlockchainAddress(),
+		SessionToken:      token,
+		ExpiresAt:         expiresAt,
+	})
+	utils.WriteJson(w, status, m)
+}
+
+// acquireKDF
+// keystoreでscryptを計算する枠を確保する。計算が終わったらreleaseを呼ぶ
+// KDF_WAIT_TIMEOUTまでに空かない場合は503とRetry-Afterを返してfalse（クライアントが切断した場合は何も返さない）
+func (ws *WalletServer) acquireKDF(w http.ResponseWriter, req *http.Request) (func(), bool) {
+	release := func() { <-ws.kdf }
+	timer := time.NewTimer(KDF_WAIT_TIMEOUT)
+	defer timer.Stop()
+	select {
+	case ws.kdf <- struct{}{}:
+		return release, true
+	case <-req.Context().Done():
+		return nil, false
+	case <-timer.C:
+		w.Header().Set("Retry-After", strconv.Itoa(int(KDF_WAIT_TIMEOUT/time.Second)))
+		utils.WriteError(w, http.StatusServiceUnavailable, utils.ERROR_UNAVAILABLE, "too many key derivations in progress")
+		return nil, false
+	}
+}
+
+// authenticate Authorizationヘッダーのセッションのアドレス
+func (ws *WalletServer) authenticate(w http.ResponseWriter, req *http.Request) (string, bool) {
+	blockchainAddress, err := ws.sessions.Address(sessionToken(req))
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, utils.ERROR_UNAUTHORIZED, err.Error())
+		return "", false
+	}
+	return blockchainAddress, true
+}
+
+// UnlockWallet passwordで秘密鍵を復号し、セッションのトークンを返す
+// 秘密鍵はlockするかセッションが切れるまでサーバーのメモリ上にだけ持つ
+func (ws *WalletServer) UnlockWallet(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		var r keystoreRequest
+		if err := utils.DecodeJsonBody(w, req, &r, MAX_REQUEST_BODY_BYTES); err != nil {
+			utils.WriteHTTPError(w, err)
+			return
+		}
+		if r.BlockchainAddress == nil || r.Password == nil {
+			utils.WriteError(w, http.StatusUnprocessableEntity, utils.ERROR_INVALID_FIELD, "missing blockchain_address or password")
+			return
+		}
+		release, ok := ws.acquireKDF(w, req)
+		if !ok {
+			return
+		}
+		myWallet, err := ws.keystore.Unlock(*r.BlockchainAddress, *r.Password)
+		release()
+		if err != nil {
+			utils.WriteHTTPError(w, keystoreError(err))
+			return
+		}
+		ws.writeSession(w, http.StatusOK, myWallet)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodPost)
+	}
+}
+
+// LockWallet セッションのwalletの秘密鍵をサーバーのメモリ上から取り除き、そのアドレスのセッションをすべて無効にする
+func (ws *WalletServer) LockWallet(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		blockchainAddress, ok := ws.authenticate(w, req)
+		if !ok {
+			return
+		}
+		ws.sessions.Revoke(blockchainAddress)
+		ws.keystore.Lock(blockchainAddress)
+		utils.WriteJson(w, http.StatusOK, utils.JsonStatus("success"))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodPost)
+	}
+}
+
+// keystoreError keystoreのエラーに対応するHTTPのエラー
+func keystoreError(err error) error {
+	switch {
+	case errors.Is(err, wallet.ErrEmptyPassword), errors.Is(err, utils.ErrInvalidAddress):
+		return utils.NewHTTPError(http.StatusUnprocessableEntity, utils.ERROR_INVALID_FIELD, err.Error())
+	case errors.Is(err, wallet.ErrWrongPassword):
+		return utils.NewHTTPError(http.StatusUnauthorized, utils.ERROR_UNAUTHORIZED, err.Error())
+	case errors.Is(err, wallet.ErrWalletNotFound):
+		return utils.NewHTTPError(http.StatusNotFound, utils.ERROR_NOT_FOUND, err.Error())
+	case errors.Is(err, wallet.ErrWalletLocked):
+		return utils.NewHTTPError(http.StatusLocked, utils.ERROR_LOCKED, err.Error())
+	case errors.Is(err, wallet.ErrWalletExists):
+		return utils.NewHTTPError(http.StatusConflict, utils.ERROR_CONFLICT, err.Error())
+	default:
+		return err
+	}
+}
+
+// CreateTransaction
+// "Authorization: Bearer <session_token>" のセッションのwalletで署名し、ブロックチェーンサーバーに送る
 func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPost:
+		blockchainAddress, ok := ws.authenticate(w, req)
+		if !ok {
+			return
+		}
+
 		// jsonのデコード
-		decoder := json.NewDecoder(req.Body)
 		var t wallet.TransactionRequest
-		err := decoder.Decode(&t)
-		if err != nil {
-			log.Printf("ERROR: %v", err)
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+		if err := utils.DecodeJsonBody(w, req, &t, MAX_REQUEST_BODY_BYTES); err != nil {
+			utils.WriteHTTPError(w, err)
 			return
 		}
 		if !t.Validate() {
-			log.Println("ERROR: missing field(s)")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			utils.WriteError(w, http.StatusUnprocessableEntity, utils.ERROR_INVALID_FIELD, "missing or invalid field(s)")
 			return
 		}
 
-		// ecdsaのstructへ変換
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
-		value, err := strconv.ParseFloat(*t.Value, 32)
+		// セッションのwalletからだけ送金できる
+		if *t.SenderBlockchainAddress != blockchainAddress {
+			utils.WriteError(w, http.StatusForbidden, utils.ERROR_FORBIDDEN,
+				fmt.Sprintf("sender %s does not match session", *t.SenderBlockchainAddress))
+			return
+		}
+		// 署名にはkeystoreでunlockしたwalletの鍵を使う
+		senderWallet, err := ws.keystore.Wallet(blockchainAddress)
 		if err != nil {
-			log.Println("ERROR: parse error")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			utils.WriteHTTPError(w, keystoreError(err))
 			return
 		}
-		value32 := float32(value)
-
-		w.Header().Add("Content-Type", "application/json")
-
-		// トランザクション情報
-		transaction := wallet.NewTransaction(privateKey, publicKey,
-			*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value32)
-		signature := transaction.GenerateSignature()
-		signatureStr := signature.String()
+		value, _ := utils.ParseAmount(*t.Value) // Validateで確認済み
 
-		bt := &block.TransactionRequest{
-			SenderBlockchainAddress:    t.SenderBlockchainAddress,
-			RecipientBlockchainAddress: t.RecipientBlockchainAddress,
-			SenderPublicKey:            t.SenderPublicKey,
-			Value:                      &value32,
-			Signature:                  &signatureStr,
+		// nonceを取得し、署名したトランザクションをブロックチェーンサーバーに送る
+		// 受け付けられなかった場合は、ブロックチェーンサーバーのcodeとreasonをそのまま返す
+		id, err := ws.client.Send(req.Context(), senderWallet, *t.RecipientBlockchainAddress, value)
+		if err != nil {
+			utils.WriteHTTPError(w, gatewayError(err))
+			return
 		}
-		m, _ := json.Marshal(bt)
-		buf := bytes.NewBuffer(m)
+		m, _ := json.Marshal(struct {
+			Message string `json:"message"`
+			ID      string `json:"id"`
+		}{
+			Message: "success",
+			ID:      id,
+		})
+		utils.WriteJson(w, http.StatusOK, m)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodPost)
+	}
+}
 
-		// トランザクション情報をブロックチェーンサーバーに送る
-		resp, _ := http.Post(ws.Gateway()+"/transactions", "application/json", buf)
-		if resp.StatusCode == 201 {
-			io.WriteString(w, string(utils.JsonStatus("success")))
+// WalletAmount ブロックチェーンサーバーからアドレスの残高を取得する
+func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		if blockchainAddress == "" {
+			utils.WriteError(w, http.StatusBadRequest, utils.ERROR_INVALID_PARAMETER, "missing blockchain_address")
 			return
 		}
-		io.WriteString(w, string(utils.JsonStatus("fail")))
+		b, err := ws.client.Balance(req.Context(), blockchainAddress)
+		if err != nil {
+			utils.WriteHTTPError(w, gatewayError(err))
+			return
+		}
+
+		m, _ := json.Marshal(struct {
+			Message string       `json:"message"`
+			Amount  utils.Amount `json:"amount"`
+			Pending utils.Amount `json:"pending"`
+		}{
+			Message: "success",
+			Amount:  b.Confirmed,
+			Pending: b.Pending,
+		})
+		utils.WriteJson(w, http.StatusOK, m)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+// Health
+// gatewayに繋がるかどうかを返す（繋がらない場合は503）
+func (ws *WalletServer) Health(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		ctx, cancel := context.WithTimeout(req.Context(), HEALTH_TIMEOUT)
+		defer cancel()
+		start := time.Now()
+		latest, err := ws.health.LatestBlock(ctx)
+		latency := time.Since(start)
+
+		type health struct {
+			Status           string `json:"status"`
+			Gateway          string `json:"gateway"`
+			GatewayReachable bool   `json:"gateway_reachable"`
+			LatencyMs        int64  `json:"latency_ms"`
+			Height           *int   `json:"height,omitempty"`
+			Reason           string `json:"reason,omitempty"`
+		}
+		h := health{
+			Status:           "ok",
+			Gateway:          ws.Gateway(),
+			GatewayReachable: err == nil,
+			LatencyMs:        latency.Milliseconds(),
+		}
+		status := http.StatusOK
+		if err != nil {
+			h.Status = "unavailable"
+			h.Reason = gatewayError(err).Error()
+			status = http.StatusServiceUnavailable
+		} else {
+			h.Height = &latest.Height
+		}
+		m, _ := json.Marshal(h)
+		utils.WriteJson(w, status, m)
 	default:
-		w.WriteHeader(http.StatusBadRequest)
-		log.Println("ERROR: Invalid HTTP Method")
+		utils.MethodNotAllowed(w, req, http.MethodGet)
 	}
 }
 
 func (ws *WalletServer) Run() {
+	go func() {
+		for range time.Tick(SESSION_SWEEP) {
+			ws.sessions.Sweep()
+		}
+	}()
+
 	http.HandleFunc("/", ws.Index)
 	http.HandleFunc("/wallet", ws.Wallet)
+	http.HandleFunc("/wallet/unlock", ws.UnlockWallet)
+	http.HandleFunc("/wallet/lock", ws.LockWallet)
 	http.HandleFunc("/transaction", ws.CreateTransaction)
+	http.HandleFunc("/wallet/amount", ws.WalletAmount)
+	http.HandleFunc("/health", ws.Health)
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(ws.Port())), nil))
 }